@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ensureProgram downloads and extracts the program named name if it has
+// no local data yet, for -auto-fetch. It returns the program's directory
+// name as listed in the index.
+func ensureProgram(programs []Program, name string) (string, error) {
+	var p *Program
+	for i := range programs {
+		if strings.EqualFold(programs[i].Name, name) {
+			p = &programs[i]
+			break
+		}
+	}
+	if p == nil {
+		if fileExists(subdomainsPath(name)) {
+			return name, nil
+		}
+		return "", fmt.Errorf("program '%s' not found", name)
+	}
+
+	destDir := programDir(p.Name)
+	if fileExists(subdomainsPath(p.Name)) {
+		return p.Name, nil
+	}
+
+	fmt.Fprintf(statusOut, "[*] %s is not downloaded, fetching...\n", p.Name)
+	zipPath, _, err := downloadZip(context.Background(), *p)
+	if err != nil {
+		return "", fmt.Errorf("download %s: %w", p.Name, err)
+	}
+	defer os.Remove(zipPath)
+
+	if needsProgramDir() {
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return "", err
+		}
+	}
+	lines, cut, err := unzip(zipPath, destDir)
+	if err != nil {
+		return "", fmt.Errorf("unzip %s: %w", p.Name, err)
+	}
+	emit(event{Type: "extract_finished", Program: p.Name, Subdomains: lines})
+
+	mf := loadManifest()
+	mf.update(p.Name, lines)
+	mf.setSampled(p.Name, cut)
+	if err := mf.save(); err != nil {
+		fmt.Fprintf(errOut, "[-] Save manifest: %v\n", err)
+	}
+	fmt.Fprintf(statusOut, "[+] %s (%s subdomains)\n", p.Name, formatCount(lines))
+	return p.Name, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// inflightBytes bounds the total size of archives that are downloaded but
+// not yet extracted. Download workers wait before starting another
+// download while the total is at or above the limit, so a slow unzip
+// stage cannot fill the temp directory. A zero limit never waits.
+type inflightBytes struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int64
+	n       int64
+	engaged bool
+}
+
+func newInflightBytes(limit int64) *inflightBytes {
+	b := &inflightBytes{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// wait blocks until the in-flight total is below the limit. A single
+// archive larger than the limit still proceeds once nothing else is in
+// flight.
+func (b *inflightBytes) wait() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.limit > 0 && b.n >= b.limit {
+		if !b.engaged {
+			b.engaged = true
+			fmt.Fprintf(statusOut, "[!] %s of archives waiting for extraction, pausing downloads\n", formatSize(b.n))
+		}
+		b.cond.Wait()
+	}
+	if b.engaged {
+		b.engaged = false
+		fmt.Fprintf(statusOut, "[*] %s waiting for extraction, resuming downloads\n", formatSize(b.n))
+	}
+}
+
+func (b *inflightBytes) add(n int64) {
+	b.mu.Lock()
+	b.n += n
+	b.mu.Unlock()
+}
+
+// done releases n bytes once their archive is extracted or discarded.
+func (b *inflightBytes) done(n int64) {
+	b.mu.Lock()
+	b.n -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
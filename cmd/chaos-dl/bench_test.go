@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Run with: go test -run '^$' -bench . ./cmd/chaos-dl
+
+const (
+	benchPrograms     = 16
+	benchLinesPerFile = 50_000
+)
+
+// benchArchive returns a zip holding one subdomains file of lines
+// synthetic hostnames under domain.
+func benchArchive(b *testing.B, domain string, lines int) []byte {
+	b.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(domain + ".txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(w, "host-%d.api-%d.%s\n", i, i%97, domain)
+	}
+	if err := zw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// benchSetup points the data directory at a temporary one, sets the
+// options main would normally fill in and silences status output. It
+// returns a function restoring the previous state.
+func benchSetup(b *testing.B) func() {
+	b.Helper()
+	savedOpts, savedStatus, savedStdout, savedClient := opts, statusOut, os.Stdout, httpClient
+	savedBase := filepath.Dir(cacheFile)
+
+	setBaseDir(b.TempDir())
+	opts = options{
+		format:    "text",
+		order:     "index",
+		wildcards: "match",
+		logic:     "and",
+		maxLine:   1 << 20,
+		writeBuf:  256 << 10,
+		overwrite: true,
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	statusOut = io.Discard
+	os.Stdout = devNull
+
+	return func() {
+		devNull.Close()
+		opts, statusOut, os.Stdout, httpClient = savedOpts, savedStatus, savedStdout, savedClient
+		setBaseDir(savedBase)
+	}
+}
+
+// benchServer serves the same synthetic archive for every program.
+func benchServer(b *testing.B, archive []byte) (*httptest.Server, []Program) {
+	b.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write(archive)
+	}))
+	b.Cleanup(srv.Close)
+	httpClient = srv.Client()
+
+	programs := make([]Program, benchPrograms)
+	for i := range programs {
+		programs[i] = Program{
+			Name:  fmt.Sprintf("bench-%02d", i),
+			URL:   fmt.Sprintf("%s/bench-%02d.zip", srv.URL, i),
+			Count: benchLinesPerFile,
+		}
+	}
+	return srv, programs
+}
+
+// BenchmarkDownloadPipeline measures whole -d runs (download, extract,
+// manifest) against a local server at several worker counts.
+func BenchmarkDownloadPipeline(b *testing.B) {
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			restore := benchSetup(b)
+			defer restore()
+			archive := benchArchive(b, "example.com", benchLinesPerFile)
+			_, programs := benchServer(b, archive)
+
+			b.SetBytes(int64(len(archive) * len(programs)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				parallelDownload(append([]Program(nil), programs...), workers)
+			}
+			b.ReportMetric(float64(b.N*len(programs))/b.Elapsed().Seconds(), "programs/s")
+		})
+	}
+}
+
+// BenchmarkDownload measures fetching archives to temp files only, at
+// several levels of concurrency.
+func BenchmarkDownload(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			restore := benchSetup(b)
+			defer restore()
+			archive := benchArchive(b, "example.com", benchLinesPerFile)
+			_, programs := benchServer(b, archive)
+
+			b.SetBytes(int64(len(archive)))
+			b.SetParallelism(workers)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					path, _, err := downloadZip(context.Background(), programs[i%len(programs)])
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					os.Remove(path)
+					i++
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "downloads/s")
+		})
+	}
+}
+
+// benchExtractArchive writes a zip of 4*benchLinesPerFile synthetic
+// hostnames split over entries entries, creates the program directory
+// to extract it into, and returns both paths with the uncompressed size.
+func benchExtractArchive(b *testing.B, entries int) (src, dest string, size int64) {
+	b.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for e := 0; e < entries; e++ {
+		w, err := zw.Create(fmt.Sprintf("part-%d.txt", e))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < 4*benchLinesPerFile/entries; i++ {
+			n, _ := fmt.Fprintf(w, "host-%d.part-%d.example.com\n", i, e)
+			size += int64(n)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	src = filepath.Join(b.TempDir(), "bench.zip")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		b.Fatal(err)
+	}
+	dest = filepath.Join(chaosDir, "bench")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		b.Fatal(err)
+	}
+	return src, dest, size
+}
+
+// benchUnzip extracts src into dest b.N times.
+func benchUnzip(b *testing.B, src, dest string, size int64) {
+	b.Helper()
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := unzip(src, dest); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkExtract measures unzip throughput for one archive, with the
+// archive split into a varying number of entries, and for a single
+// entry at several -write-buf sizes.
+func BenchmarkExtract(b *testing.B) {
+	for _, entries := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("entries=%d", entries), func(b *testing.B) {
+			restore := benchSetup(b)
+			defer restore()
+			src, dest, size := benchExtractArchive(b, entries)
+			benchUnzip(b, src, dest, size)
+		})
+	}
+	for _, writeBuf := range []string{"4K", "64K", "256K", "1M"} {
+		b.Run("write-buf="+writeBuf, func(b *testing.B) {
+			restore := benchSetup(b)
+			defer restore()
+			n, err := parseSize(writeBuf)
+			if err != nil {
+				b.Fatal(err)
+			}
+			opts.writeBuf = int(n)
+			src, dest, size := benchExtractArchive(b, 1)
+			benchUnzip(b, src, dest, size)
+		})
+	}
+}
+
+// BenchmarkExtractWorkersIO measures unzip throughput through the
+// -workers-io writer pool. 0 writes from the unzip worker directly.
+func BenchmarkExtractWorkersIO(b *testing.B) {
+	for _, workers := range []int{0, 1, 2, 4} {
+		b.Run(fmt.Sprintf("workers-io=%d", workers), func(b *testing.B) {
+			restore := benchSetup(b)
+			defer restore()
+			opts.ioWorkers = workers
+			resetIOPool()
+			defer resetIOPool()
+			src, dest, size := benchExtractArchive(b, 1)
+			benchUnzip(b, src, dest, size)
+		})
+	}
+}
+
+// resetIOPool stops the -workers-io pool so the next ioPool call starts
+// one for the current opts.
+func resetIOPool() {
+	if sharedPool != nil {
+		for _, q := range sharedPool.queues {
+			close(q)
+		}
+	}
+	diskPoolOnce, sharedPool = sync.Once{}, nil
+}
+
+// BenchmarkCountMatches measures the per-file scan used to pick the
+// best-matching program.
+func BenchmarkCountMatches(b *testing.B) {
+	restore := benchSetup(b)
+	defer restore()
+
+	path := filepath.Join(b.TempDir(), "subdomains.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var size int64
+	for i := 0; i < 1_000_000; i++ {
+		n, _ := fmt.Fprintf(f, "host-%d.api-%d.example.com\n", i, i%97)
+		size += int64(n)
+	}
+	if err := f.Close(); err != nil {
+		b.Fatal(err)
+	}
+	m := newQueryMatcher([]string{"api-42."})
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := countMatches(context.Background(), path, m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	bloomMagic = "CDLBLOOM1"
+
+	// bloomFPRate is the target false-positive rate of the filter.
+	bloomFPRate = 0.01
+
+	// bloomBytesPerEntry estimates the number of subdomains from the size
+	// of the data so the filter can be sized without a counting pass. It
+	// errs low, which over-sizes the filter rather than raising the
+	// false-positive rate.
+	bloomBytesPerEntry = 16
+)
+
+// bloomFilter is a fixed-size bloom filter using double hashing over two
+// FNV variants.
+type bloomFilter struct {
+	fingerprint uint64
+	k           uint32
+	bits        []uint64
+}
+
+func newBloomFilter(n int64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(bloomFPRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomFilter{k: k, bits: make([]uint64, (m+63)/64)}
+}
+
+func bloomHashes(s string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	io.WriteString(h1, s)
+	h2 := fnv.New64()
+	io.WriteString(h2, s)
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+func (b *bloomFilter) add(s string) {
+	h1, h2 := bloomHashes(s)
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloomFilter) mayContain(s string) bool {
+	h1, h2 := bloomHashes(s)
+	m := uint64(len(b.bits)) * 64
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// falsePositiveRate estimates the filter's current false-positive rate
+// from the fraction of bits set.
+func (b *bloomFilter) falsePositiveRate() float64 {
+	set := 0
+	for _, w := range b.bits {
+		set += bits.OnesCount64(w)
+	}
+	return math.Pow(float64(set)/float64(len(b.bits)*64), float64(b.k))
+}
+
+// addFile adds every line of the subdomain list at path.
+func (b *bloomFilter) addFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		if line := strings.ToLower(strings.TrimSpace(scanner.Text())); line != "" {
+			b.add(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (b *bloomFilter) save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString(bloomMagic)
+	binary.Write(w, binary.LittleEndian, b.fingerprint)
+	binary.Write(w, binary.LittleEndian, b.k)
+	binary.Write(w, binary.LittleEndian, uint64(len(b.bits)))
+	binary.Write(w, binary.LittleEndian, b.bits)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func loadBloomFilter(path string) (*bloomFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	magic := make([]byte, len(bloomMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != bloomMagic {
+		return nil, errors.New("not a bloom filter file")
+	}
+	b := &bloomFilter{}
+	var words uint64
+	binary.Read(r, binary.LittleEndian, &b.fingerprint)
+	binary.Read(r, binary.LittleEndian, &b.k)
+	if err := binary.Read(r, binary.LittleEndian, &words); err != nil {
+		return nil, err
+	}
+	b.bits = make([]uint64, words)
+	if err := binary.Read(r, binary.LittleEndian, b.bits); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// dataFingerprint hashes the path, size and modification time of every
+// subdomains.txt so a stale filter is detected without reading the data.
+// It also returns the total size of the files.
+func dataFingerprint() (uint64, int64, []string) {
+	h := fnv.New64a()
+	var total int64
+	var files []string
+	filepath.WalkDir(chaosDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		name, flat := flatProgram(d.Name())
+		if dir := filepath.Dir(path); d.Name() != "subdomains.txt" && !(flat && (dir == chaosDir || inShard(chaosDir, dir, name))) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+		total += info.Size()
+		files = append(files, path)
+		return nil
+	})
+	return h.Sum64(), total, files
+}
+
+// openBloomFilter returns the persisted filter, rebuilding it when the
+// data has changed since it was written.
+func openBloomFilter() (*bloomFilter, error) {
+	fingerprint, total, files := dataFingerprint()
+	if b, err := loadBloomFilter(bloomFile); err == nil && b.fingerprint == fingerprint {
+		return b, nil
+	}
+
+	return buildBloomFilter(fingerprint, total, files)
+}
+
+// buildBloomFilter builds and saves a filter over files from scratch.
+func buildBloomFilter(fingerprint uint64, total int64, files []string) (*bloomFilter, error) {
+	fmt.Fprintf(statusOut, "[*] Building bloom filter over %d programs...\n", len(files))
+	b := newBloomFilter(total / bloomBytesPerEntry)
+	b.fingerprint = fingerprint
+	for _, path := range files {
+		if err := b.addFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.save(bloomFile); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// bloomUpdate adds the programs extracted by a download run to an
+// existing bloom filter, so -exists does not rebuild it over the whole
+// dataset after every sync.
+type bloomUpdate struct {
+	b        *bloomFilter
+	mu       sync.Mutex
+	programs []string
+}
+
+// openBloomUpdate returns an update for the persisted filter, or nil when
+// there is none or it was already stale before the run; a stale filter
+// is rebuilt by the next -exists as before.
+func openBloomUpdate() *bloomUpdate {
+	b, err := loadBloomFilter(bloomFile)
+	if err != nil {
+		return nil
+	}
+	if fingerprint, _, _ := dataFingerprint(); b.fingerprint != fingerprint {
+		return nil
+	}
+	return &bloomUpdate{b: b}
+}
+
+// add notes that name's list was extracted in this run.
+func (u *bloomUpdate) add(name string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.programs = append(u.programs, name)
+}
+
+// finish adds the extracted programs' names to the filter and saves it.
+// Names a re-download removed cannot be taken out of a bloom filter;
+// they stay as false positives, which -exists rules out with its exact
+// scan. Once those and the new names push the false-positive rate well
+// past its target, the filter is rebuilt instead.
+func (u *bloomUpdate) finish() error {
+	if u == nil || len(u.programs) == 0 {
+		return nil
+	}
+	for _, name := range u.programs {
+		if err := u.b.addFile(subdomainsPath(name)); err != nil {
+			return err
+		}
+	}
+	fingerprint, total, files := dataFingerprint()
+	if u.b.falsePositiveRate() > 2*bloomFPRate {
+		_, err := buildBloomFilter(fingerprint, total, files)
+		return err
+	}
+	u.b.fingerprint = fingerprint
+	if err := u.b.save(bloomFile); err != nil {
+		return err
+	}
+	fmt.Fprintf(statusOut, "[*] Added %d programs to the bloom filter\n", len(u.programs))
+	return nil
+}
+
+// existsQuery answers whether domain is stored anywhere. A bloom filter
+// rejects most absent names immediately; positives are confirmed with an
+// exact scan that also reports the programs containing the name.
+func existsQuery(domain string) bool {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	b, err := openBloomFilter()
+	if err != nil {
+		fmt.Fprintf(errOut, "[-] Bloom filter: %v\n", err)
+		os.Exit(1)
+	}
+	if !b.mayContain(domain) {
+		fmt.Fprintf(statusOut, "[-] %s not found\n", domain)
+		return false
+	}
+
+	_, _, files := dataFingerprint()
+	failures := &scanFailures{}
+	defer failures.report()
+	var found []string
+	for _, path := range files {
+		ok, err := containsLine(path, domain)
+		if err != nil {
+			failures.record(path, err)
+		}
+		if ok {
+			found = append(found, programOf(path))
+		}
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(statusOut, "[-] %s not found\n", domain)
+		return false
+	}
+
+	out, _ := newFormatter(opts.format, os.Stdout)
+	for _, program := range found {
+		out.Write(record{
+			Text:   fmt.Sprintf("[+] %s found in %s", domain, program),
+			Fields: []field{{"subdomain", domain}, {"program", program}},
+		})
+	}
+	out.Close()
+	return true
+}
+
+func containsLine(path, domain string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		if strings.EqualFold(strings.TrimSpace(scanner.Text()), domain) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseSize parses a byte size such as "500M", "20G" or "1048576".
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type cachedProgram struct {
+	name     string
+	size     int64
+	lastUsed time.Time
+}
+
+// dirSize returns the total size of the regular files below dir.
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// enforceCacheLimit evicts the least recently queried programs until the
+// extracted data fits in limit bytes. Programs never queried fall back to
+// their last update time. Eviction needs -yes or an interactive
+// confirmation.
+func enforceCacheLimit(limit int64) error {
+	entries, err := programEntries(chaosDir)
+	if err != nil {
+		return err
+	}
+
+	mf := loadManifest()
+	var programs []cachedProgram
+	var total int64
+	for _, e := range entries {
+		p := cachedProgram{name: e.Name()}
+		if e.IsDir() {
+			p.size = dirSize(filepath.Join(e.dir, e.Name()))
+		} else if name, ok := flatProgram(e.Name()); ok {
+			p.name = name
+			if info, err := e.Info(); err == nil {
+				p.size = info.Size()
+			}
+		} else {
+			continue
+		}
+		if me, ok := mf.Programs[p.name]; ok {
+			p.lastUsed = me.Updated
+			if me.Accessed.After(p.lastUsed) {
+				p.lastUsed = me.Accessed
+			}
+		} else if info, err := e.Info(); err == nil {
+			p.lastUsed = info.ModTime()
+		}
+		programs = append(programs, p)
+		total += p.size
+	}
+	if total <= limit {
+		return nil
+	}
+
+	sort.Slice(programs, func(i, j int) bool {
+		return programs[i].lastUsed.Before(programs[j].lastUsed)
+	})
+	var evict []cachedProgram
+	var freed int64
+	for _, p := range programs {
+		if total-freed <= limit {
+			break
+		}
+		evict = append(evict, p)
+		freed += p.size
+	}
+
+	fmt.Fprintf(statusOut, "[*] Cache is %s, over the %s limit; %d programs (%s) would be evicted\n",
+		formatSize(total), formatSize(limit), len(evict), formatSize(freed))
+	if !opts.yes && !confirm("Evict them?") {
+		fmt.Fprintln(statusOut, "[*] Eviction skipped (use -yes to evict without asking)")
+		return nil
+	}
+
+	for _, p := range evict {
+		if err := removeProgram(p.name); err != nil {
+			return err
+		}
+		delete(mf.Programs, p.name)
+		fmt.Fprintf(statusOut, "[-] Evicted %s (%s, last used %s)\n",
+			p.name, formatSize(p.size), p.lastUsed.Format(time.DateOnly))
+	}
+	return mf.save()
+}
+
+// confirm asks a yes/no question on the terminal. It returns false when
+// stdin is not interactive. The prompt goes straight to stderr, like
+// -pick's: errOut may be a -log-format json log, which holds text back
+// until a newline.
+func confirm(question string) bool {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// runCheck requests target with the shared client and reports status,
+// timing, TLS details and the remote address. The body is not read.
+func runCheck(target string) error {
+	var (
+		dnsStart, connStart, tlsStart time.Time
+		dnsDone, connDone, tlsDone    time.Duration
+		remoteAddr                    string
+		firstByte                     time.Duration
+	)
+
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { dnsDone = time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connStart = time.Now() },
+		ConnectDone:       func(string, string, error) { connDone = time.Since(connStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tlsDone = time.Since(tlsStart)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			remoteAddr = info.Conn.RemoteAddr().String()
+		},
+		GotFirstResponseByte: func() { firstByte = time.Since(start) },
+	}
+
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	fmt.Printf("[*] Checking %s\n", target)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	fmt.Printf("[*] Status:     %s\n", resp.Status)
+	fmt.Printf("[*] Remote:     %s\n", remoteAddr)
+	fmt.Printf("[*] DNS:        %v\n", dnsDone)
+	fmt.Printf("[*] Connect:    %v\n", connDone)
+	if resp.TLS != nil {
+		fmt.Printf("[*] TLS:        %v (%s, %s)\n", tlsDone,
+			tls.VersionName(resp.TLS.Version), tls.CipherSuiteName(resp.TLS.CipherSuite))
+		if len(resp.TLS.PeerCertificates) > 0 {
+			cert := resp.TLS.PeerCertificates[0]
+			fmt.Printf("[*] Cert:       %s (issuer: %s, expires %s)\n",
+				cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.Format(time.DateOnly))
+		}
+	}
+	fmt.Printf("[*] First byte: %v\n", firstByte)
+	if resp.ContentLength >= 0 {
+		fmt.Printf("[*] Size:       %d bytes\n", resp.ContentLength)
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	fmt.Println("[+] OK")
+	return nil
+}
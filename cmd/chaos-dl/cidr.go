@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// cidrMatcher matches stored "subdomain,ip,..." records with an address
+// inside one of its prefixes. Lines without addresses never match.
+type cidrMatcher struct {
+	prefixes []netip.Prefix
+}
+
+func (m cidrMatcher) Match(line string) bool {
+	_, ips := splitRecord(line)
+	return len(m.inRange(ips)) > 0
+}
+
+// inRange returns the addresses of ips that fall inside a prefix of m.
+func (m cidrMatcher) inRange(ips []string) []string {
+	var hits []string
+	for _, s := range ips {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		for _, p := range m.prefixes {
+			if p.Contains(addr) {
+				hits = append(hits, s)
+				break
+			}
+		}
+	}
+	return hits
+}
+
+// parseCIDRs parses a comma-separated list of prefixes. A bare address is
+// taken as a single-host prefix.
+func parseCIDRs(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("no ranges given")
+	}
+	return prefixes, nil
+}
+
+// splitRecord splits a stored line into its hostname and any addresses
+// that follow it, as written by -resolve -show-ips.
+func splitRecord(line string) (string, []string) {
+	parts := strings.Split(strings.TrimSpace(line), ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts[0], parts[1:]
+}
+
+// cidrQuery prints the subdomains, across every program, whose stored
+// addresses fall inside spec.
+func cidrQuery(spec string, workers int) error {
+	prefixes, err := parseCIDRs(spec)
+	if err != nil {
+		return err
+	}
+	m := cidrMatcher{prefixes: prefixes}
+
+	w, closeTee, err := queryWriter()
+	if err != nil {
+		return err
+	}
+	defer closeTee()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failures := &scanFailures{}
+	defer failures.report()
+
+	lines := scanAll(ctx, m, workers, failures)
+	matches := make(chan Match, cap(lines))
+	go func() {
+		defer close(matches)
+		for match := range lines {
+			host, ips := splitRecord(match.Subdomain)
+			match.Subdomain = host
+			match.IPs = m.inRange(ips)
+			select {
+			case matches <- match:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	var results <-chan Match = matches
+	if where != nil {
+		results = filterMatches(ctx, results, where)
+	}
+
+	out, _ := newFormatter(opts.format, w)
+	out = limitOutput(out, cancel)
+	report := newQueryReport(spec)
+	used := writeMatches(results, out, report)
+	out.Close()
+	if opts.report != "" {
+		if err := report.write(opts.report); err != nil {
+			fmt.Fprintf(errOut, "[-] Write report: %v\n", err)
+		}
+	}
+
+	touchPrograms(used)
+	return nil
+}
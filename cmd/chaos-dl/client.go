@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// h2PingTimeout is how long an HTTP/2 health check ping may go
+// unanswered before the connection is closed.
+const h2PingTimeout = 15 * time.Second
+
+// newHTTPClient builds the client shared by the index fetch, downloads
+// and -check, so all of them see the same transport configuration.
+func newHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = dialer.DialContext
+	if opts.dnsCacheTTL > 0 {
+		transport.DialContext = newDNSCache(opts.dnsCacheTTL).dialContext(dialer)
+	}
+
+	// Resuming TLS sessions makes replacing a dropped connection cheap on
+	// long runs. Only sessions from fully verified handshakes are cached,
+	// so -pin still holds for resumed connections.
+	transport.TLSClientConfig = &tls.Config{
+		ClientSessionCache: tls.NewLRUClientSessionCache(64),
+	}
+	if opts.pin != "" {
+		pins, err := parsePins(opts.pin)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.VerifyPeerCertificate = verifyPins(pins)
+	}
+
+	// HTTP/2 health checks: ping a connection that has been silent for
+	// -h2-ping and drop it if the ping goes unanswered, instead of letting
+	// the next download hang on a stale connection until it times out.
+	if opts.h2Ping > 0 {
+		transport.HTTP2 = &http.HTTP2Config{
+			SendPingTimeout: opts.h2Ping,
+			PingTimeout:     h2PingTimeout,
+		}
+	}
+
+	return &http.Client{Transport: transport, CheckRedirect: checkRedirect}, nil
+}
+
+// checkRedirect keeps the default limit of 10 redirects and refuses a
+// redirect from HTTPS to another scheme unless -allow-insecure-http is
+// set, so a redirect cannot get around checkScheme.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	prev := via[len(via)-1].URL
+	if !opts.allowInsecure && strings.EqualFold(prev.Scheme, "https") && !strings.EqualFold(req.URL.Scheme, "https") {
+		return fmt.Errorf("refusing redirect to %s URL %s: %w (use -allow-insecure-http)", req.URL.Scheme, req.URL.Redacted(), errInsecureURL)
+	}
+	return nil
+}
+
+// parsePins decodes a comma-separated list of SHA-256 public key hashes,
+// each given as hex or standard base64.
+func parsePins(s string) ([][]byte, error) {
+	var pins [][]byte
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(p), "sha256/"))
+		if p == "" {
+			continue
+		}
+		sum, err := hex.DecodeString(strings.ReplaceAll(p, ":", ""))
+		if err != nil {
+			sum, err = base64.StdEncoding.DecodeString(p)
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin %q: want a SHA-256 hash in hex or base64", p)
+		}
+		pins = append(pins, sum)
+	}
+	if len(pins) == 0 {
+		return nil, errors.New("no pins given")
+	}
+	return pins, nil
+}
+
+// verifyPins accepts a connection only if some certificate in the chain
+// presented by the server has a public key matching one of pins. It runs
+// after normal chain verification, which stays enabled.
+func verifyPins(pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				continue
+			}
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin) {
+					return nil
+				}
+			}
+		}
+		return errors.New("certificate public key does not match -pin")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// snapshotPrograms returns the programs of a data directory laid out like
+// chaos/, in any layout, mapped to their list. A program stored in
+// several layouts maps to the list in the configured one.
+func snapshotPrograms(dir string) (map[string]string, error) {
+	entries, err := programEntries(dir)
+	if err != nil {
+		return nil, err
+	}
+	programs := make(map[string]string)
+	rank := make(map[string]int)
+	for _, e := range entries {
+		name, path, flat := e.Name(), "", false
+		if e.IsDir() {
+			path = filepath.Join(e.dir, name, "subdomains.txt")
+			if !fileExists(path) {
+				continue
+			}
+		} else {
+			var ok bool
+			if name, ok = flatProgram(name); !ok {
+				continue
+			}
+			path, flat = filepath.Join(e.dir, e.Name()), true
+		}
+		r := 1
+		if flat == opts.flat {
+			r += 2
+		}
+		if (e.dir != dir) == opts.shard {
+			r++
+		}
+		if r > rank[name] {
+			programs[name], rank[name] = path, r
+		}
+	}
+	return programs, nil
+}
+
+// readSnapshotSet is readSet for a program that may be missing from one
+// side of a comparison.
+func readSnapshotSet(path string) (map[string]bool, error) {
+	if path == "" {
+		return map[string]bool{}, nil
+	}
+	return readSet(path)
+}
+
+// compareSnapshots reports, for two data directories, the subdomains
+// added and removed per program and how many subdomains of b appear
+// nowhere in a. With -full every change is printed instead of the
+// per-program counts.
+func compareSnapshots(a, b string) error {
+	progsA, err := snapshotPrograms(a)
+	if err != nil {
+		return err
+	}
+	progsB, err := snapshotPrograms(b)
+	if err != nil {
+		return err
+	}
+
+	seenA := make(map[string]bool)
+	for _, path := range progsA {
+		set, err := readSet(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for s := range set {
+			seenA[s] = true
+		}
+	}
+
+	names := make([]string, 0, len(progsA)+len(progsB))
+	for name := range progsA {
+		names = append(names, name)
+	}
+	for name := range progsB {
+		if _, ok := progsA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out, _ := newFormatter(opts.format, os.Stdout)
+	var changed, totalAdded, totalRemoved, fresh int
+	for _, name := range names {
+		before, err := readSnapshotSet(progsA[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", progsA[name], err)
+		}
+		after, err := readSnapshotSet(progsB[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", progsB[name], err)
+		}
+		added, removed := setDiff(before, after)
+		if len(added) == 0 && len(removed) == 0 {
+			continue
+		}
+		changed++
+		totalAdded += len(added)
+		totalRemoved += len(removed)
+
+		for _, s := range added {
+			change, text := "added", "+ "+name+" "+s
+			if !seenA[s] {
+				change, text = "new", text+" (new)"
+				seenA[s] = true
+				fresh++
+			}
+			if opts.full {
+				out.Write(record{Text: text, Fields: []field{{"program", name}, {"change", change}, {"subdomain", s}}})
+			}
+		}
+		if opts.full {
+			for _, s := range removed {
+				out.Write(record{Text: "- " + name + " " + s, Fields: []field{{"program", name}, {"change", "removed"}, {"subdomain", s}}})
+			}
+			continue
+		}
+		out.Write(record{
+			Text:   fmt.Sprintf("%s: +%d -%d", name, len(added), len(removed)),
+			Fields: []field{{"program", name}, {"added", len(added)}, {"removed", len(removed)}},
+		})
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(errOut, "[*] %d programs changed: %s added, %s removed, %s new across the dataset\n",
+		changed, formatCount(totalAdded), formatCount(totalRemoved), formatCount(fresh))
+	return nil
+}
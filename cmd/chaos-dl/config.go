@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configKeys maps config file keys that are not flag names.
+var configKeys = map[string]string{
+	"workers":       "w",
+	"query_workers": "qw",
+}
+
+// configPath returns the config file named by -config, CHAOS_CONFIG or
+// the default ~/.config/chaos-dl/config.yaml, and whether it was chosen
+// explicitly. -config has to be found before flag.Parse because the file
+// supplies defaults for the other flags.
+func configPath() (string, bool) {
+	args := os.Args[1:]
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if hasValue {
+			return value, true
+		}
+		if i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	if p := os.Getenv("CHAOS_CONFIG"); p != "" {
+		return p, true
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(dir, "chaos-dl", "config.yaml"), false
+}
+
+// applyConfig sets flag defaults from the config file. It runs before the
+// environment and the command line, which both take precedence. A
+// missing default file is not an error.
+func applyConfig() error {
+	path, explicit := configPath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			return nil
+		}
+		return err
+	}
+	entries, err := parseConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, e := range entries {
+		key := strings.ReplaceAll(e.key, "_", "-")
+		if key == "dir" {
+			setBaseDir(e.value)
+			settingSources["dir"] = fmt.Sprintf("config %s:%d", path, e.line)
+			continue
+		}
+		if name, ok := configKeys[key]; ok {
+			key = name
+		}
+		if flag.Lookup(key) == nil || envSkip[key] || key == "config" {
+			fmt.Fprintf(errOut, "[!] %s:%d: unknown key %q\n", path, e.line, e.key)
+			continue
+		}
+		if err := flag.Set(key, e.value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, e.line, e.key, err)
+		}
+		settingSources[key] = fmt.Sprintf("config %s:%d", path, e.line)
+	}
+	return nil
+}
+
+type configEntry struct {
+	key, value string
+	line       int
+}
+
+// parseConfig reads the flat subset of YAML a settings file needs:
+// "key: value" pairs with optional quoting and # comments, where a list
+// is written either as [a, b] or as "- item" lines under an empty key.
+// Lists become comma-separated values, as the flags expect.
+func parseConfig(data []byte) ([]configEntry, error) {
+	var entries []configEntry
+	list := -1 // index of the entry collecting "- item" lines
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		if item, ok := strings.CutPrefix(line, "- "); ok && list >= 0 && raw != line {
+			value, err := configScalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			e := &entries[list]
+			if e.value != "" {
+				e.value += ","
+			}
+			e.value += value
+			continue
+		}
+
+		key, rest, ok := strings.Cut(line, ":")
+		if !ok || raw != strings.TrimLeft(raw, " \t") {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", n)
+		}
+		key = strings.TrimSpace(key)
+		rest = strings.TrimSpace(rest)
+		list = -1
+
+		var value string
+		switch {
+		case rest == "" || strings.HasPrefix(rest, "#"):
+			list = len(entries)
+		case strings.HasPrefix(rest, "["):
+			end := strings.LastIndex(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated list", n)
+			}
+			var items []string
+			for _, item := range strings.Split(rest[1:end], ",") {
+				if item = strings.TrimSpace(item); item == "" {
+					continue
+				}
+				v, err := configScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				items = append(items, v)
+			}
+			value = strings.Join(items, ",")
+		default:
+			v, err := configScalar(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			value = v
+		}
+		entries = append(entries, configEntry{key: key, value: value, line: n})
+	}
+	return entries, scanner.Err()
+}
+
+// configScalar unquotes a scalar, drops a trailing comment from an
+// unquoted one, and maps YAML's yes/no/on/off to true/false.
+func configScalar(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				return strconv.Unquote(s[:i+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if strings.HasPrefix(s, "'") {
+		end := strings.LastIndexByte(s, '\'')
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	switch strings.ToLower(s) {
+	case "yes", "on":
+		return "true", nil
+	case "no", "off":
+		return "false", nil
+	}
+	return s, nil
+}
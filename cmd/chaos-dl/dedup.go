@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// dedupMemNames bounds the names -dedup-across-programs keeps in
+	// memory; beyond it they are spilled to sorted runs on disk.
+	dedupMemNames = 1 << 20
+	// dedupMergeRuns is how many runs may pile up before they are merged
+	// into one, which bounds the open files and the fan-in at close.
+	dedupMergeRuns = 16
+)
+
+// seenSet collects names with the program each was first seen in. Recent
+// names live in a map; older ones are spilled to sorted runs in the -tmp
+// directory. Nothing is looked up during the run: close merges the runs
+// like sort -u, so memory stays bounded on runs over the whole dataset
+// and no name is ever dropped for another.
+type seenSet struct {
+	mem  map[string]string
+	runs []*os.File
+}
+
+func newSeenSet() *seenSet {
+	return &seenSet{mem: make(map[string]string)}
+}
+
+// add records that program has name, unless an earlier add in memory
+// already did. Older runs are not consulted; merge resolves those.
+func (s *seenSet) add(name, program string) error {
+	if _, ok := s.mem[name]; ok {
+		return nil
+	}
+	s.mem[name] = program
+	if len(s.mem) >= dedupMemNames {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill writes the in-memory names to a new sorted run, merging the
+// runs into one once there are dedupMergeRuns of them.
+func (s *seenSet) spill() error {
+	names := make([]string, 0, len(s.mem))
+	for name := range s.mem {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	f, err := createRun()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(f, opts.writeBuf)
+	for _, name := range names {
+		writeRecord(w, name, s.mem[name])
+	}
+	if err := finishRun(f, w); err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	s.mem = make(map[string]string)
+
+	if len(s.runs) < dedupMergeRuns {
+		return nil
+	}
+	f, err = createRun()
+	if err != nil {
+		return err
+	}
+	w = bufio.NewWriterSize(f, opts.writeBuf)
+	if _, err := s.merge(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := finishRun(f, w); err != nil {
+		return err
+	}
+	s.close()
+	s.runs = []*os.File{f}
+	return nil
+}
+
+// createRun opens an unlinked temporary file for a run.
+func createRun() (*os.File, error) {
+	f, err := os.CreateTemp(opts.tmpDir, "chaos-seen-*.run")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(f.Name())
+	return f, nil
+}
+
+// finishRun flushes a run written through w and rewinds it for merging.
+// f is closed on error.
+func finishRun(f *os.File, w *bufio.Writer) error {
+	err := w.Flush()
+	if err == nil {
+		_, err = f.Seek(0, 0)
+	}
+	if err != nil {
+		f.Close()
+	}
+	return err
+}
+
+func writeRecord(w *bufio.Writer, name, program string) {
+	w.WriteString(name)
+	w.WriteByte('\t')
+	w.WriteString(program)
+	w.WriteByte('\n')
+}
+
+// runCursor is the current record of one run during a merge. Runs are
+// numbered in spill order, and the in-memory names come last.
+type runCursor struct {
+	name, program string
+	order         int
+	next          func() bool
+}
+
+type runHeap []*runCursor
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].name != h[j].name {
+		return h[i].name < h[j].name
+	}
+	return h[i].order < h[j].order
+}
+func (h runHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)   { *h = append(*h, x.(*runCursor)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// merge writes every name once to w in sorted order, attributed to the
+// earliest run that had it, and returns how many it wrote. It consumes
+// the runs and the in-memory names.
+func (s *seenSet) merge(w *bufio.Writer) (int, error) {
+	var h runHeap
+	var readErr error
+	for i, f := range s.runs {
+		r := bufio.NewReaderSize(f, opts.writeBuf)
+		c := &runCursor{order: i}
+		c.next = func() bool {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				if err != io.EOF && readErr == nil {
+					readErr = err
+				}
+				return false
+			}
+			c.name, c.program, _ = strings.Cut(line[:len(line)-1], "\t")
+			return true
+		}
+		if c.next() {
+			h = append(h, c)
+		}
+	}
+	names := make([]string, 0, len(s.mem))
+	for name := range s.mem {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	mem := &runCursor{order: len(s.runs)}
+	mem.next = func() bool {
+		if len(names) == 0 {
+			return false
+		}
+		mem.name, mem.program, names = names[0], s.mem[names[0]], names[1:]
+		return true
+	}
+	if mem.next() {
+		h = append(h, mem)
+	}
+	heap.Init(&h)
+
+	n := 0
+	last := ""
+	for h.Len() > 0 {
+		c := h[0]
+		if n == 0 || c.name != last {
+			writeRecord(w, c.name, c.program)
+			last = c.name
+			n++
+		}
+		if c.next() {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	s.mem = make(map[string]string)
+	return n, readErr
+}
+
+func (s *seenSet) close() {
+	for _, f := range s.runs {
+		f.Close()
+	}
+	s.runs = nil
+}
+
+// globalDedup builds the -dedup-across-programs list: every subdomain of
+// the run written once, tab-separated from the program it was first seen
+// in. Programs are added as their extraction finishes, and the list is
+// written sorted when the run closes it.
+type globalDedup struct {
+	mu      sync.Mutex
+	seen    *seenSet
+	f       *os.File
+	written int
+}
+
+func openGlobalDedup(path string) (*globalDedup, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &globalDedup{seen: newSeenSet(), f: f}, nil
+}
+
+// add records the names of program's list at path.
+func (g *globalDedup) add(program, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	scanner := newLineScanner(in)
+	for scanner.Scan() {
+		name := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if name == "" {
+			continue
+		}
+		if err := g.seen.add(name, program); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// close writes the merged list and sets written to its length.
+func (g *globalDedup) close() error {
+	w := bufio.NewWriterSize(g.f, opts.writeBuf)
+	n, err := g.seen.merge(w)
+	g.seen.close()
+	g.written = n
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
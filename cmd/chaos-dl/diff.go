@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// readSet loads the non-empty lines of path into a set.
+func readSet(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := make(map[string]bool)
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			set[line] = true
+		}
+	}
+	return set, scanner.Err()
+}
+
+// setDiff returns the sorted elements of b missing from a (added) and of
+// a missing from b (removed).
+func setDiff(a, b map[string]bool) (added, removed []string) {
+	for s := range b {
+		if !a[s] {
+			added = append(added, s)
+		}
+	}
+	for s := range a {
+		if !b[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// diffSubdomains prints the subdomains added and removed between the
+// previous extraction of program (kept by -keep-previous) and the current
+// one.
+func diffSubdomains(program string) error {
+	path := subdomainsPath(program)
+	prev, err := readSet(previousPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no previous snapshot for %s (download it with -keep-previous)", program)
+		}
+		return err
+	}
+	cur, err := readSet(path)
+	if err != nil {
+		return err
+	}
+
+	added, removed := setDiff(prev, cur)
+	out, _ := newFormatter(opts.format, os.Stdout)
+	for _, s := range added {
+		out.Write(record{Text: "+ " + s, Fields: []field{{"change", "added"}, {"subdomain", s}}})
+	}
+	for _, s := range removed {
+		out.Write(record{Text: "- " + s, Fields: []field{{"change", "removed"}, {"subdomain", s}}})
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(errOut, "[*] %s: %d added, %d removed\n", program, len(added), len(removed))
+	return nil
+}
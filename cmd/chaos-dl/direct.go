@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// directProgram describes an archive given by URL with -url, outside the
+// index. Without a name the program is named after the archive file.
+func directProgram(rawURL, name string) (Program, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return Program{}, fmt.Errorf("invalid archive URL %q", rawURL)
+	}
+	if name == "" {
+		name = path.Base(u.Path)
+		for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+			name = strings.TrimSuffix(name, ext)
+		}
+	}
+	if err := validProgramName(name); err != nil {
+		return Program{}, fmt.Errorf("%v (use -name)", err)
+	}
+	// The size is unknown; any nonzero count keeps parallelDownload from
+	// skipping the program as empty.
+	return Program{Name: name, URL: u.String(), Count: 1}, nil
+}
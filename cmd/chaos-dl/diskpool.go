@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"sync"
+)
+
+// diskQueueDepth is how many buffered chunks each -workers-io writer can
+// have queued before extraction blocks on it.
+const diskQueueDepth = 8
+
+// diskPool is the -workers-io writer pool. Extraction hands it filled
+// write buffers so decompression continues while a small, fixed number
+// of goroutines does the disk writes. Each file is pinned to one writer,
+// which keeps its chunks in order.
+type diskPool struct {
+	queues []chan diskWrite
+	mu     sync.Mutex
+	next   int
+}
+
+type diskWrite struct {
+	file *pooledFile
+	data []byte
+}
+
+var (
+	diskPoolOnce sync.Once
+	sharedPool   *diskPool
+)
+
+// ioPool returns the writer pool, or nil when -workers-io is 0 and
+// extraction writes directly.
+func ioPool() *diskPool {
+	diskPoolOnce.Do(func() {
+		if opts.ioWorkers < 1 {
+			return
+		}
+		sharedPool = &diskPool{}
+		for i := 0; i < opts.ioWorkers; i++ {
+			q := make(chan diskWrite, diskQueueDepth)
+			sharedPool.queues = append(sharedPool.queues, q)
+			go func() {
+				for w := range q {
+					if _, err := w.file.f.Write(w.data); err != nil {
+						w.file.fail(err)
+					}
+					w.file.pending.Done()
+				}
+			}()
+		}
+	})
+	return sharedPool
+}
+
+func (p *diskPool) open(f *os.File) *pooledFile {
+	p.mu.Lock()
+	q := p.queues[p.next%len(p.queues)]
+	p.next++
+	p.mu.Unlock()
+	return &pooledFile{f: f, q: q}
+}
+
+// pooledFile queues writes to f on its pool writer. Write copies the
+// chunk and returns at once; errors surface on later writes and on wait.
+type pooledFile struct {
+	f       *os.File
+	q       chan diskWrite
+	pending sync.WaitGroup
+	mu      sync.Mutex
+	err     error
+}
+
+func (pf *pooledFile) Write(p []byte) (int, error) {
+	if err := pf.firstErr(); err != nil {
+		return 0, err
+	}
+	pf.pending.Add(1)
+	pf.q <- diskWrite{file: pf, data: append([]byte(nil), p...)}
+	return len(p), nil
+}
+
+func (pf *pooledFile) fail(err error) {
+	pf.mu.Lock()
+	if pf.err == nil {
+		pf.err = err
+	}
+	pf.mu.Unlock()
+}
+
+func (pf *pooledFile) firstErr() error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return pf.err
+}
+
+// wait blocks until every queued chunk is on disk.
+func (pf *pooledFile) wait() error {
+	pf.pending.Wait()
+	return pf.firstErr()
+}
+
+// extractWriter returns the buffered writer extraction uses for f and a
+// function that flushes it, and with -workers-io waits for the pool to
+// finish writing. It must be called before f is closed.
+func extractWriter(f *os.File) (*bufio.Writer, func() error) {
+	pool := ioPool()
+	if pool == nil {
+		w := bufio.NewWriterSize(f, opts.writeBuf)
+		return w, w.Flush
+	}
+	pf := pool.open(f)
+	w := bufio.NewWriterSize(pf, opts.writeBuf)
+	return w, func() error {
+		err := w.Flush()
+		if werr := pf.wait(); err == nil {
+			err = werr
+		}
+		return err
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// countDistinct prints, for -count-distinct, how many distinct
+// subdomains among matches each program has, and how many there are
+// overall, in place of the matches. Names are compared case-insensitively
+// and with any stored addresses dropped, so repeated lines count once.
+// It returns the programs that had matches.
+func countDistinct(matches <-chan Match, w io.Writer) map[string]bool {
+	perProgram := make(map[string]map[string]struct{})
+	overall := make(map[string]struct{})
+	lines := 0
+	for m := range matches {
+		lines++
+		host, _ := splitRecord(m.Subdomain)
+		host = strings.ToLower(host)
+		set, ok := perProgram[m.Program]
+		if !ok {
+			set = make(map[string]struct{})
+			perProgram[m.Program] = set
+		}
+		set[host] = struct{}{}
+		overall[host] = struct{}{}
+	}
+
+	used := make(map[string]bool, len(perProgram))
+	programs := make([]string, 0, len(perProgram))
+	for p := range perProgram {
+		used[p] = true
+		programs = append(programs, p)
+	}
+	sort.Strings(programs)
+
+	out, _ := newFormatter(opts.format, w)
+	for _, p := range programs {
+		out.Write(record{
+			Text:   fmt.Sprintf("%-30s %12s", p, formatCount(len(perProgram[p]))),
+			Fields: []field{{"program", p}, {"distinct", len(perProgram[p])}},
+		})
+	}
+	out.Close()
+	fmt.Fprintf(statusOut, "[*] %s distinct subdomains across %d programs (%s matching lines)\n",
+		formatCount(len(overall)), len(programs), formatCount(lines))
+	return used
+}
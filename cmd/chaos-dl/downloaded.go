@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// listDownloaded lists the programs under chaos/ with a non-empty
+// subdomains.txt, without reading the index. With -long it adds the
+// subdomain count (from the manifest, counted when missing), the file
+// size and when it was last extracted.
+func listDownloaded() error {
+	programs, err := snapshotPrograms(chaosDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	mf := loadManifest()
+
+	names := make([]string, 0, len(programs))
+	for name := range programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out, _ := newFormatter(opts.format, os.Stdout)
+	defer out.Close()
+	for _, name := range names {
+		path := programs[name]
+		info, err := os.Stat(path)
+		if err != nil || info.Size() == 0 {
+			continue
+		}
+		if !opts.long {
+			out.Write(record{Text: name, Fields: []field{{"name", name}}})
+			continue
+		}
+
+		count, updated, sampled := 0, info.ModTime(), false
+		if e, ok := mf.get(name); ok {
+			count, sampled = e.Subdomains, e.Sampled
+			if !e.Updated.IsZero() {
+				updated = e.Updated
+			}
+		} else if n, err := countLines(path); err == nil {
+			count = n
+		}
+		text := formatLong(name, count, info.Size(), updated)
+		if sampled {
+			text += "  (sampled)"
+		}
+		out.Write(record{
+			Text: text,
+			Fields: []field{
+				{"name", name},
+				{"subdomains", count},
+				{"bytes", info.Size()},
+				{"updated", updated.UTC().Format(time.RFC3339)},
+				{"sampled", sampled},
+			},
+		})
+	}
+	return nil
+}
+
+func formatLong(name string, count int, size int64, updated time.Time) string {
+	return fmt.Sprintf("%-30s %12s %10s  %s", name, formatCount(count), formatSize(size),
+		updated.Local().Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// maxEntryWorkers bounds how many entries of one archive are
+// decompressed at once. Archives are already extracted in parallel, so
+// this only needs to keep a single large archive from dominating the
+// unzip stage.
+const maxEntryWorkers = 4
+
+// entryQueueDepth is how many decompressed segments of one entry may
+// wait for the list writer before its worker blocks.
+const entryQueueDepth = 4
+
+// errEntriesStopped ends the workers of an extraction that failed or
+// was cut short. It is never returned to callers.
+var errEntriesStopped = errors.New("extraction stopped")
+
+// extractEntries appends the lines of entries to counter in archive
+// order. With more than one entry they are decompressed concurrently
+// into in-memory segments of -write-buf bytes, and each entry's
+// segments are written once the entries before it are done. Entries are
+// handed out in order, so the one being written always has a worker,
+// and memory stays bounded by maxEntryWorkers and entryQueueDepth.
+// Entries are read one at a time by a -sample run, which usually stops
+// in the first, and on a single CPU, where the workers would only add
+// copying.
+func extractEntries(entries []*zip.File, counter *lineCounter, dest string, limit *sizeLimit) error {
+	program := filepath.Base(dest)
+
+	if len(entries) < 2 || counter.max > 0 || runtime.GOMAXPROCS(0) < 2 {
+		for _, f := range entries {
+			if err := extractEntry(f, counter, limit); err != nil {
+				return &UnzipError{Program: program, Entry: f.Name, Err: err}
+			}
+		}
+		return nil
+	}
+
+	workers := min(maxEntryWorkers, len(entries))
+	streams := make([]*entryStream, len(entries))
+	for i := range streams {
+		streams[i] = &entryStream{segs: make(chan []byte, entryQueueDepth)}
+	}
+	free := make(chan []byte, workers*(entryQueueDepth+1))
+	stop := make(chan struct{})
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for j := range entries {
+			select {
+			case jobs <- j:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				streams[j].fill(entries[j], limit, free, stop)
+			}
+		}()
+	}
+
+	for j, s := range streams {
+		for seg := range s.segs {
+			if _, err := counter.Write(seg); err != nil {
+				return &UnzipError{Program: program, Entry: entries[j].Name, Err: err}
+			}
+			select {
+			case free <- seg[:0]:
+			default:
+			}
+		}
+		if s.err != nil {
+			return &UnzipError{Program: program, Entry: entries[j].Name, Err: s.err}
+		}
+	}
+	return nil
+}
+
+func extractEntry(f *zip.File, counter *lineCounter, limit *sizeLimit) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return copyEntry(counter, limit.reader(rc))
+}
+
+// entryStream carries the decompressed lines of one entry to the list
+// writer. err is set before segs is closed.
+type entryStream struct {
+	segs chan []byte
+	err  error
+}
+
+// fill decompresses f into s, taking segment buffers from free and
+// stopping early once stop is closed.
+func (s *entryStream) fill(f *zip.File, limit *sizeLimit, free chan []byte, stop <-chan struct{}) {
+	defer close(s.segs)
+	w := &segmentWriter{segs: s.segs, free: free, stop: stop}
+	err := extractEntry(f, &lineCounter{w: w}, limit)
+	if err == nil {
+		err = w.flush()
+	}
+	s.err = err
+}
+
+// segmentWriter fills -write-buf sized segments and queues each one on
+// segs when it is full. The list writer hands written segments back on
+// free for reuse.
+type segmentWriter struct {
+	buf  []byte
+	segs chan<- []byte
+	free chan []byte
+	stop <-chan struct{}
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			select {
+			case w.buf = <-w.free:
+			default:
+				w.buf = make([]byte, 0, opts.writeBuf)
+			}
+		}
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf, p, n = w.buf[:len(w.buf)+c], p[c:], n+c
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush queues the partly filled segment, if any.
+func (w *segmentWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	select {
+	case w.segs <- w.buf:
+		w.buf = nil
+		return nil
+	case <-w.stop:
+		return errEntriesStopped
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envNames overrides the environment variable read for a flag; every
+// other flag is read from CHAOS_<NAME>, with dashes as underscores.
+var envNames = map[string]string{
+	"w":  "CHAOS_WORKERS",
+	"qw": "CHAOS_QUERY_WORKERS",
+}
+
+// envSkip lists the flags that select what to do rather than how; they
+// are only taken from the command line.
+var envSkip = map[string]bool{
+	"u": true, "l": true, "d": true, "q": true, "exists": true, "members": true, "cidr": true,
+	"import": true, "diff-subs": true, "merge-into": true, "union": true, "reextract": true, "url": true, "compare": true, "history": true, "list-downloaded": true,
+	"validate": true, "check": true, "pick": true, "config": true, "explain": true, "mirror": true, "purge-index-cache": true,
+}
+
+func envName(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return name
+	}
+	return "CHAOS_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets flag defaults from the environment. It runs before
+// flag.Parse, so flags given on the command line take precedence.
+func applyEnv() error {
+	if dir := os.Getenv("CHAOS_DIR"); dir != "" {
+		setBaseDir(dir)
+		settingSources["dir"] = "env CHAOS_DIR"
+	}
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || envSkip[f.Name] {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if e := flag.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("%s: %v", name, e)
+			return
+		}
+		settingSources[f.Name] = "env " + name
+	})
+	return err
+}
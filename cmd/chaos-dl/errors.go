@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DownloadError is returned by downloadZip. StatusCode is set when the
+// server answered with a non-200 status; otherwise Err holds the network
+// or IO failure. RetryAfter is the wait a 429 response asked for, if any.
+type DownloadError struct {
+	Program    string
+	StatusCode int
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *DownloadError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("status %d", e.StatusCode)
+	}
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// errInsecureURL is wrapped in the DownloadError for a program URL, or a
+// redirect from HTTPS, that is not HTTPS when -allow-insecure-http is not
+// set.
+var errInsecureURL = errors.New("not HTTPS")
+
+// errTooLarge is wrapped in the UnzipError for an archive that
+// decompresses to more than -max-uncompressed.
+var errTooLarge = errors.New("archive too large")
+
+// errURLExpired is wrapped in the DownloadError for a download refused
+// because its signed URL had expired and no fresh one was available.
+var errURLExpired = errors.New("signed URL expired")
+
+// errNotModified is wrapped in the DownloadError for a -mirror archive
+// the server answered 304 Not Modified; the kept copy is current.
+var errNotModified = errors.New("not modified")
+
+// isDiskFull reports whether err comes from a write to a full disk.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// UnzipError is returned by unzip. Entry is the archive member being
+// processed when the failure happened, empty if the archive itself could
+// not be opened or the output could not be created.
+type UnzipError struct {
+	Program string
+	Entry   string
+	Err     error
+}
+
+func (e *UnzipError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UnzipError) Unwrap() error {
+	return e.Err
+}
+
+// ScanError is sent by Query for a file that could not be read
+// completely; the matches already streamed from it are kept.
+type ScanError struct {
+	Path string
+	Err  error
+}
+
+func (e *ScanError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// errorCategory classifies a download or extraction failure for summaries.
+func errorCategory(err error) string {
+	var de *DownloadError
+	if errors.As(err, &de) && de.Err == nil {
+		switch {
+		case de.StatusCode >= 500:
+			return "5xx"
+		case de.StatusCode >= 400:
+			return "4xx"
+		}
+		return "http"
+	}
+
+	var ne net.Error
+	switch {
+	case errors.Is(err, errInsecureURL):
+		return "insecure"
+	case errors.Is(err, errTooLarge):
+		return "too-large"
+	case errors.Is(err, errURLExpired):
+		return "expired"
+	case isDiskFull(err):
+		return "disk-full"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrChecksum), errors.Is(err, zip.ErrAlgorithm):
+		return "zip-corrupt"
+	}
+
+	var ue *UnzipError
+	if errors.As(err, &ue) {
+		var ce flate.CorruptInputError
+		if errors.As(err, &ce) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "zip-corrupt"
+		}
+		return "io"
+	}
+	if errors.As(err, &ne) {
+		return "network"
+	}
+	return "io"
+}
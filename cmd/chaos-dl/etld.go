@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// registrableDomain returns the eTLD+1 of host per the public suffix
+// list, e.g. example.co.uk for a.b.example.co.uk or bucket.s3.amazonaws.com
+// for x.bucket.s3.amazonaws.com. A host that is itself a public suffix
+// is returned unchanged.
+func registrableDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(host, "*.")), ".")
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
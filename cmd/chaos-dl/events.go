@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// event is one machine-readable progress event written by -events.
+type event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Program    string    `json:"program,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	Total      int64     `json:"total,omitempty"`
+	Subdomains int       `json:"subdomains,omitempty"`
+	Duration   float64   `json:"duration_seconds,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// progressInterval throttles download_progress events per download.
+const progressInterval = 500 * time.Millisecond
+
+var (
+	eventsMu  sync.Mutex
+	eventsEnc *json.Encoder
+	eventsOut io.Closer
+)
+
+// openEvents starts the event stream on spec, which is either a file
+// descriptor number inherited from the parent process or a file path.
+func openEvents(spec string) error {
+	var f *os.File
+	if fd, err := strconv.Atoi(spec); err == nil {
+		f = os.NewFile(uintptr(fd), "events")
+	} else if f, err = os.Create(spec); err != nil {
+		return err
+	}
+	eventsEnc = json.NewEncoder(f)
+	eventsOut = f
+	return nil
+}
+
+func closeEvents() {
+	if eventsOut != nil {
+		eventsOut.Close()
+	}
+}
+
+// emit writes ev to the event stream, if one is open.
+func emit(ev event) {
+	if eventsEnc == nil {
+		return
+	}
+	ev.Time = time.Now().UTC()
+	eventsMu.Lock()
+	eventsEnc.Encode(ev)
+	eventsMu.Unlock()
+}
+
+// progressReader emits download_progress events while r is read and
+// feeds the -progress-bar display.
+type progressReader struct {
+	r       io.Reader
+	program string
+	total   int64
+	read    int64
+	last    time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	progress.update(p.program, p.read)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		emit(event{Type: "download_progress", Program: p.program, Bytes: p.read, Total: p.total})
+	}
+	return n, err
+}
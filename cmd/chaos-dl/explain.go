@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// settingSources records where a setting was taken from when it did not
+// come from its default: "config <file>:<line>", "env <NAME>" or "flag".
+// Keys are flag names, plus "dir" for the data directory.
+var settingSources = make(map[string]string)
+
+// markCommandLine records the flags given on the command line. It parses
+// the arguments again into a set of recording flags, since flag.Parse
+// does not tell them apart from values set by the config file or the
+// environment.
+func markCommandLine() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flag.VisitAll(func(f *flag.Flag) {
+		boolFlag := false
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			boolFlag = b.IsBoolFlag()
+		}
+		fs.Var(&recordingFlag{name: f.Name, boolFlag: boolFlag}, f.Name, "")
+	})
+	fs.Parse(os.Args[1:])
+}
+
+type recordingFlag struct {
+	name     string
+	boolFlag bool
+}
+
+func (r *recordingFlag) String() string   { return "" }
+func (r *recordingFlag) IsBoolFlag() bool { return r.boolFlag }
+
+func (r *recordingFlag) Set(string) error {
+	settingSources[r.name] = "flag"
+	return nil
+}
+
+// explainConfig prints every setting in effect after the config file,
+// environment and command line were applied, with where it came from.
+// Flags selecting what to do are listed only when given.
+func explainConfig(w io.Writer) error {
+	out, _ := newFormatter(opts.format, w)
+	write := func(name, value, source string) {
+		out.Write(record{
+			Text:   fmt.Sprintf("%-30s %-30s %s", name, value, source),
+			Fields: []field{{"setting", name}, {"value", value}, {"source", source}},
+		})
+	}
+
+	path, _ := configPath()
+	configSource := "default"
+	if s, ok := settingSources["config"]; ok {
+		configSource = s
+	} else if os.Getenv("CHAOS_CONFIG") != "" {
+		configSource = "env CHAOS_CONFIG"
+	}
+	if _, err := os.Stat(path); err != nil {
+		path += " (not found)"
+	}
+	write("config", path, configSource)
+	write("dir", filepath.Dir(cacheFile), sourceOf("dir"))
+
+	// The transport takes its proxy from HTTPS_PROXY, HTTP_PROXY and
+	// NO_PROXY only.
+	proxy, proxySource := "none", "default"
+	if req, err := http.NewRequest("GET", indexURL, nil); err == nil {
+		if u, err := http.ProxyFromEnvironment(req); err == nil && u != nil {
+			proxy, proxySource = u.Redacted(), "env"
+		}
+	}
+	write("proxy", proxy, proxySource)
+
+	flag.VisitAll(func(f *flag.Flag) {
+		source := sourceOf(f.Name)
+		if f.Name == "config" || envSkip[f.Name] && source == "default" {
+			return
+		}
+		write(f.Name, f.Value.String(), source)
+	})
+	return out.Close()
+}
+
+func sourceOf(name string) string {
+	if s, ok := settingSources[name]; ok {
+		return s
+	}
+	return "default"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// failedArchivePath is where -keep-failed-zip keeps the archive of a
+// program whose extraction failed.
+func failedArchivePath(name string) string {
+	return filepath.Join(failedDir, name+".zip")
+}
+
+// failedArchives collects the archives a download run kept with
+// -keep-failed-zip, for the report at the end.
+type failedArchives struct {
+	mu    sync.Mutex
+	names []string
+}
+
+// keep moves the archive at zipPath to failed/<name>.zip.
+func (f *failedArchives) keep(name, zipPath string) error {
+	if err := os.MkdirAll(failedDir, 0755); err != nil {
+		return err
+	}
+	if err := moveFile(zipPath, failedArchivePath(name)); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.names = append(f.names, name)
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *failedArchives) report() {
+	if len(f.names) == 0 {
+		return
+	}
+	sort.Strings(f.names)
+	fmt.Fprintf(errOut, "[!] Kept %d archives that failed to extract in %s (retry with -reextract): %s\n",
+		len(f.names), failedDir, strings.Join(f.names, ", "))
+}
+
+// reextract extracts the archives kept in failed/, removing each one
+// that now extracts cleanly. It returns the number still failing.
+func reextract() (int, error) {
+	entries, err := os.ReadDir(failedDir)
+	if os.IsNotExist(err) {
+		fmt.Fprintln(statusOut, "[*] No failed archives to re-extract")
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	mf := loadManifest()
+	done, failed := 0, 0
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".zip")
+		if e.IsDir() || !ok {
+			continue
+		}
+		src := filepath.Join(failedDir, e.Name())
+		dest := programDir(name)
+		if needsProgramDir() {
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				fmt.Fprintf(errOut, "[-] Unzip %s: %v\n", name, err)
+				failed++
+				continue
+			}
+		}
+		lines, cut, err := unzip(src, dest)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] Unzip %s: %v\n", name, err)
+			failed++
+			continue
+		}
+		if !opts.raw {
+			mf.update(name, lines)
+			mf.setSampled(name, cut)
+		}
+		os.Remove(src)
+		fmt.Fprintf(statusOut, "[+] %s (%s subdomains)\n", name, formatCount(lines))
+		done++
+	}
+	if err := mf.save(); err != nil {
+		fmt.Fprintf(errOut, "[-] Save manifest: %v\n", err)
+	}
+	fmt.Fprintf(statusOut, "[*] Re-extracted %d archives, %d still failing\n", done, failed)
+	return failed, nil
+}
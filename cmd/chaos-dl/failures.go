@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// failureEntry counts the consecutive failed downloads of a program. URL
+// and Count are the index entry at the time, so a changed entry starts
+// over.
+type failureEntry struct {
+	Consecutive int       `json:"consecutive"`
+	LastAttempt time.Time `json:"last_attempt"`
+	URL         string    `json:"url"`
+	Count       int       `json:"count"`
+}
+
+// failureTracker is stored in chaos/failures.json so programs that keep
+// failing (usually stale index entries) can be skipped by later -d all
+// runs. It is safe for concurrent use.
+type failureTracker struct {
+	mu       sync.Mutex
+	path     string
+	Programs map[string]*failureEntry `json:"programs"`
+}
+
+func loadFailureTracker() *failureTracker {
+	t := &failureTracker{
+		path:     filepath.Join(chaosDir, "failures.json"),
+		Programs: make(map[string]*failureEntry),
+	}
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return t
+	}
+	json.Unmarshal(data, t)
+	if t.Programs == nil {
+		t.Programs = make(map[string]*failureEntry)
+	}
+	return t
+}
+
+// record notes the outcome of a download attempt of p.
+func (t *failureTracker) record(p Program, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !failed {
+		delete(t.Programs, p.Name)
+		return
+	}
+	e, ok := t.Programs[p.Name]
+	if !ok || e.URL != p.URL || e.Count != p.Count {
+		e = &failureEntry{URL: p.URL, Count: p.Count}
+		t.Programs[p.Name] = e
+	}
+	e.Consecutive++
+	e.LastAttempt = time.Now().UTC()
+}
+
+// dead returns how often p has failed in a row when that reaches
+// -dead-after and its index entry is unchanged since.
+func (t *failureTracker) dead(p Program) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.Programs[p.Name]
+	if !ok || opts.deadAfter <= 0 || e.URL != p.URL || e.Count != p.Count {
+		return 0, false
+	}
+	return e.Consecutive, e.Consecutive >= opts.deadAfter
+}
+
+func (t *failureTracker) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// skipDead drops the programs that have failed -dead-after times in a
+// row unless -retry-dead is set.
+func skipDead(programs []Program) []Program {
+	if opts.retryDead {
+		return programs
+	}
+	t := loadFailureTracker()
+	var kept []Program
+	for _, p := range programs {
+		if n, ok := t.dead(p); ok {
+			fmt.Fprintf(statusOut, "[=] %s (failed %d times in a row, use -retry-dead)\n", p.Name, n)
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	// fdsPerWorker estimates the descriptors one -w worker can hold at
+	// once: a download connection and temp file, plus an unzip worker's
+	// archive and output file.
+	fdsPerWorker = 4
+
+	// fdReserve is kept free for the index, manifest, stdio and the
+	// resolver.
+	fdReserve = 32
+)
+
+// capWorkers lowers workers so concurrent downloads and extractions stay
+// under the process's open file limit, raising the soft limit toward the
+// hard limit first where the platform allows. name is the flag reported
+// when workers is lowered.
+func capWorkers(name string, workers int) int {
+	limit, ok := openFileLimit()
+	if !ok || limit <= fdReserve {
+		return workers
+	}
+	limitWorkers := max(int((limit-fdReserve)/fdsPerWorker), 1)
+	if workers > limitWorkers {
+		fmt.Fprintf(errOut, "[!] -%s %d would exceed the open file limit (%d), using %d workers\n", name, workers, limit, limitWorkers)
+		return limitWorkers
+	}
+	return workers
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// openFileLimit reports that the platform has no RLIMIT_NOFILE to
+// respect.
+func openFileLimit() (uint64, bool) {
+	return 0, false
+}
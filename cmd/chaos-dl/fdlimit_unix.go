@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// openFileLimit raises the soft RLIMIT_NOFILE to the hard limit if
+// possible and returns the resulting soft limit.
+func openFileLimit() (uint64, bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, false
+	}
+	if rl.Cur < rl.Max {
+		raised := rl
+		raised.Cur = rl.Max
+		if syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised) == nil {
+			rl = raised
+		}
+	}
+	return uint64(rl.Cur), true
+}
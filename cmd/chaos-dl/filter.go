@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// filterPrograms applies -platform and -tag to the index. A filter on a
+// field no program in the index carries is ignored with a warning, since
+// older indexes lack the metadata entirely.
+func filterPrograms(programs []Program) []Program {
+	if opts.platform == "" && opts.tag == "" {
+		return programs
+	}
+
+	var hasPlatform, hasTags bool
+	for _, p := range programs {
+		hasPlatform = hasPlatform || p.Platform != ""
+		hasTags = hasTags || len(p.Tags) > 0
+	}
+	platform, tag := opts.platform, opts.tag
+	if platform != "" && !hasPlatform {
+		fmt.Fprintln(errOut, "[!] Index has no platform data, ignoring -platform")
+		platform = ""
+	}
+	if tag != "" && !hasTags {
+		fmt.Fprintln(errOut, "[!] Index has no tag data, ignoring -tag")
+		tag = ""
+	}
+
+	var kept []Program
+	for _, p := range programs {
+		if platform != "" && !matchesAny(platform, p.Platform) {
+			continue
+		}
+		if tag != "" && !matchesAny(tag, p.Tags...) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	return kept
+}
+
+// matchesAny reports whether one of the comma-separated values in want
+// equals one of have, ignoring case.
+func matchesAny(want string, have ...string) bool {
+	for _, w := range strings.Split(want, ",") {
+		w = strings.TrimSpace(w)
+		for _, h := range have {
+			if strings.EqualFold(w, h) {
+				return true
+			}
+		}
+	}
+	return false
+}
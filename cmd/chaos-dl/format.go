@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type field struct {
+	Name  string
+	Value any
+}
+
+// record is one unit of output. Text is the line printed by the text
+// format; Fields are the columns used by the structured formats.
+type record struct {
+	Text   string
+	Fields []field
+}
+
+// MarshalJSON encodes the fields as an object, keeping their order.
+func (r record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range r.Fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.Name)
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// formatter renders records selected by -format. Flush pushes buffered
+// records to the writer where the format allows partial output. Close
+// must be called to flush buffered output and, for json, emit the
+// enclosing array.
+type formatter interface {
+	Write(rec record) error
+	Flush() error
+	Close() error
+}
+
+func validFormat(format string) bool {
+	switch format {
+	case "text", "json", "jsonl", "csv", "hosts":
+		return true
+	}
+	return false
+}
+
+func newFormatter(format string, w io.Writer) (formatter, error) {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case "text":
+		return &textFormatter{w: bw}, nil
+	case "json":
+		return &jsonFormatter{w: bw}, nil
+	case "jsonl":
+		return &jsonlFormatter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case "csv":
+		return &csvFormatter{w: bw, cw: csv.NewWriter(bw)}, nil
+	case "hosts":
+		return &hostsFormatter{w: bw, hosts: make(map[string][]string)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type textFormatter struct {
+	w *bufio.Writer
+}
+
+func (f *textFormatter) Write(rec record) error {
+	_, err := fmt.Fprintln(f.w, rec.Text)
+	return err
+}
+
+func (f *textFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *textFormatter) Close() error {
+	return f.w.Flush()
+}
+
+type jsonFormatter struct {
+	w       *bufio.Writer
+	records []record
+}
+
+func (f *jsonFormatter) Write(rec record) error {
+	f.records = append(f.records, rec)
+	return nil
+}
+
+// Flush does nothing: a json array is only complete once closed.
+func (f *jsonFormatter) Flush() error {
+	return nil
+}
+
+func (f *jsonFormatter) Close() error {
+	if f.records == nil {
+		f.records = []record{}
+	}
+	data, err := json.MarshalIndent(f.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	f.w.Write(data)
+	f.w.WriteByte('\n')
+	return f.w.Flush()
+}
+
+type jsonlFormatter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (f *jsonlFormatter) Write(rec record) error {
+	return f.enc.Encode(rec)
+}
+
+func (f *jsonlFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+func (f *jsonlFormatter) Close() error {
+	return f.w.Flush()
+}
+
+type csvFormatter struct {
+	w      *bufio.Writer
+	cw     *csv.Writer
+	header bool
+}
+
+func (f *csvFormatter) Write(rec record) error {
+	if !f.header {
+		names := make([]string, len(rec.Fields))
+		for i, fl := range rec.Fields {
+			names[i] = fl.Name
+		}
+		if err := f.cw.Write(names); err != nil {
+			return err
+		}
+		f.header = true
+	}
+	values := make([]string, len(rec.Fields))
+	for i, fl := range rec.Fields {
+		if list, ok := fl.Value.([]string); ok {
+			values[i] = strings.Join(list, " ")
+		} else {
+			values[i] = fmt.Sprint(fl.Value)
+		}
+	}
+	return f.cw.Write(values)
+}
+
+func (f *csvFormatter) Flush() error {
+	f.cw.Flush()
+	if err := f.cw.Error(); err != nil {
+		return err
+	}
+	return f.w.Flush()
+}
+
+func (f *csvFormatter) Close() error {
+	f.cw.Flush()
+	if err := f.cw.Error(); err != nil {
+		return err
+	}
+	return f.w.Flush()
+}
+
+// hostsPerLine caps the hostnames on one hosts file line; some resolvers
+// ignore names past the first few on a long line.
+const hostsPerLine = 8
+
+// hostsFormatter writes resolved query matches as /etc/hosts entries,
+// grouping the hostnames of each address onto shared lines. Addresses
+// keep the order they were first seen in. Records without resolved
+// addresses are skipped.
+type hostsFormatter struct {
+	w     *bufio.Writer
+	ips   []string
+	hosts map[string][]string
+	seen  map[string]bool
+}
+
+func (f *hostsFormatter) Write(rec record) error {
+	var host string
+	var ips []string
+	for _, fl := range rec.Fields {
+		switch fl.Name {
+		case "subdomain":
+			host, _ = fl.Value.(string)
+		case "ips":
+			ips, _ = fl.Value.([]string)
+		}
+	}
+	if host == "" {
+		return nil
+	}
+	if f.seen == nil {
+		f.seen = make(map[string]bool)
+	}
+	for _, ip := range ips {
+		key := ip + " " + strings.ToLower(host)
+		if f.seen[key] {
+			continue
+		}
+		f.seen[key] = true
+		if _, ok := f.hosts[ip]; !ok {
+			f.ips = append(f.ips, ip)
+		}
+		f.hosts[ip] = append(f.hosts[ip], host)
+	}
+	return nil
+}
+
+// Flush does nothing: a hostname may still join an earlier address.
+func (f *hostsFormatter) Flush() error {
+	return nil
+}
+
+func (f *hostsFormatter) Close() error {
+	for _, ip := range f.ips {
+		names := f.hosts[ip]
+		for len(names) > 0 {
+			n := min(len(names), hostsPerLine)
+			fmt.Fprintf(f.w, "%s\t%s\n", ip, strings.Join(names[:n], " "))
+			names = names[n:]
+		}
+	}
+	return f.w.Flush()
+}
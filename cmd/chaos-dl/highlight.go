@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	ansiHighlight = "\x1b[1;31m"
+	ansiReset     = "\x1b[0m"
+)
+
+// spanMatcher is implemented by matchers that can report where in a line
+// they matched, for highlighting.
+type spanMatcher interface {
+	Span(line string) (start, end int, ok bool)
+}
+
+// highlighter marks the matched part of each subdomain in text query
+// output. It is nil unless color is on.
+var highlighter spanMatcher
+
+func (m substringMatcher) Span(line string) (int, int, bool) {
+	lower := strings.ToLower(line)
+	i := strings.Index(lower, m.term)
+	if i < 0 || len(lower) != len(line) {
+		return 0, 0, false
+	}
+	return i, i + len(m.term), true
+}
+
+func (m wildcardMatcher) Span(line string) (int, int, bool) {
+	if s, ok := m.inner.(spanMatcher); ok {
+		return s.Span(line)
+	}
+	return 0, 0, false
+}
+
+// Span of a combination is the span of its first matching term.
+func (m allMatcher) Span(line string) (int, int, bool) {
+	return anyMatcher(m).Span(line)
+}
+
+func (m anyMatcher) Span(line string) (int, int, bool) {
+	for _, inner := range m {
+		if s, ok := inner.(spanMatcher); ok && inner.Match(line) {
+			if start, end, ok := s.Span(line); ok {
+				return start, end, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// highlight wraps the span of s reported by highlighter in color codes.
+func highlight(s string) string {
+	if highlighter == nil {
+		return s
+	}
+	start, end, ok := highlighter.Span(s)
+	if !ok || start == end {
+		return s
+	}
+	return s[:start] + ansiHighlight + s[start:end] + ansiReset + s[end:]
+}
+
+// useColor resolves -color: always, never, or auto, which colors text
+// output only when stdout is a terminal and NO_COLOR is unset.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if _, ok := os.LookupEnv("NO_COLOR"); ok || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("unknown -color mode %q (want auto, always or never)", mode)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const historyLayout = "20060102"
+
+// saveIndexHistory archives the freshly fetched index as
+// history/index-YYYYMMDD.json.gz, replacing an earlier snapshot from the
+// same day, then prunes snapshots older than -history-retention days.
+func saveIndexHistory() error {
+	if err := os.MkdirAll(historyDir, 0755); err != nil {
+		return err
+	}
+
+	src, err := os.Open(cacheFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	name := "index-" + time.Now().UTC().Format(historyLayout) + ".json.gz"
+	path := filepath.Join(historyDir, name)
+	tmp := path + ".tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if opts.historyRetention > 0 {
+		return pruneIndexHistory(time.Now().UTC().AddDate(0, 0, -opts.historyRetention))
+	}
+	return nil
+}
+
+// historySnapshots returns the dated snapshots in the history directory,
+// oldest first.
+func historySnapshots() ([]time.Time, []string) {
+	entries, _ := os.ReadDir(historyDir)
+	var dates []time.Time
+	var paths []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "index-") || !strings.HasSuffix(name, ".json.gz") {
+			continue
+		}
+		day, err := time.Parse(historyLayout, strings.TrimSuffix(strings.TrimPrefix(name, "index-"), ".json.gz"))
+		if err != nil {
+			continue
+		}
+		dates = append(dates, day)
+		paths = append(paths, filepath.Join(historyDir, name))
+	}
+	return dates, paths
+}
+
+func pruneIndexHistory(cutoff time.Time) error {
+	dates, paths := historySnapshots()
+	for i, day := range dates {
+		if day.Before(cutoff) {
+			if err := os.Remove(paths[i]); err != nil {
+				return err
+			}
+			fmt.Fprintf(statusOut, "[-] Pruned index snapshot %s\n", filepath.Base(paths[i]))
+		}
+	}
+	return nil
+}
+
+// readSnapshot decodes the programs of one archived index.
+func readSnapshot(path string) ([]Program, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var programs []Program
+	if err := json.NewDecoder(zr).Decode(&programs); err != nil {
+		return nil, err
+	}
+	return programs, nil
+}
+
+// historyQuery answers -history over the archived index snapshots. A
+// date (YYYY-MM-DD) lists the programs in the latest snapshot taken on or
+// before that day; anything else is a program name whose presence and
+// subdomain count are printed as a timeline, one line per change.
+func historyQuery(arg string) error {
+	dates, paths := historySnapshots()
+	if len(dates) == 0 {
+		return fmt.Errorf("no index snapshots in %s (use -save-index-history with -u)", historyDir)
+	}
+	if day, err := time.Parse("2006-01-02", arg); err == nil {
+		return programsOn(day, dates, paths)
+	}
+	return programTimeline(arg, dates, paths)
+}
+
+func programsOn(day time.Time, dates []time.Time, paths []string) error {
+	i := sort.Search(len(dates), func(i int) bool { return dates[i].After(day) }) - 1
+	if i < 0 {
+		return fmt.Errorf("no snapshot on or before %s (oldest is %s)", day.Format("2006-01-02"), dates[0].Format("2006-01-02"))
+	}
+	programs, err := readSnapshot(paths[i])
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(paths[i]), err)
+	}
+	fmt.Fprintf(statusOut, "[*] %d programs in the snapshot of %s\n", len(programs), dates[i].Format("2006-01-02"))
+	listPrograms(programs)
+	return nil
+}
+
+func programTimeline(name string, dates []time.Time, paths []string) error {
+	out, _ := newFormatter(opts.format, os.Stdout)
+	var first, last time.Time
+	present, count := false, 0
+	for i, day := range dates {
+		programs, err := readSnapshot(paths[i])
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] %s: %v\n", filepath.Base(paths[i]), err)
+			continue
+		}
+		found, n := false, 0
+		for _, p := range programs {
+			if strings.EqualFold(p.Name, name) {
+				found, n = true, p.Count
+				break
+			}
+		}
+
+		status := ""
+		switch {
+		case found && !present:
+			status = "added"
+			if !first.IsZero() {
+				status = "returned"
+			}
+		case !found && present:
+			status = "removed"
+		case found && n != count:
+			status = "changed"
+		}
+		present, count = found, n
+		if found {
+			if first.IsZero() {
+				first = day
+			}
+			last = day
+		}
+		if status == "" {
+			continue
+		}
+
+		date := day.Format("2006-01-02")
+		text := fmt.Sprintf("%s  %-8s  %s subdomains", date, status, formatCount(n))
+		if !found {
+			text = date + "  " + status
+		}
+		out.Write(record{Text: text, Fields: []field{
+			{"date", date},
+			{"program", name},
+			{"status", status},
+			{"count", n},
+		}})
+	}
+
+	out.Close()
+
+	if first.IsZero() {
+		return fmt.Errorf("program '%s' is not in any of %d snapshots", name, len(dates))
+	}
+	fmt.Fprintf(statusOut, "[*] %s: first seen %s, last seen %s (%d snapshots)\n",
+		name, first.Format("2006-01-02"), last.Format("2006-01-02"), len(dates))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import "strings"
+
+// isValidHostname reports whether s looks like a DNS hostname: dot
+// separated labels of letters, digits, hyphens and underscores, each 1-63
+// characters, at most 253 characters in total. A leading "*." wildcard is
+// allowed.
+func isValidHostname(s string) bool {
+	s = strings.TrimPrefix(s, "*.")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// normalizeHostname lowercases s and strips surrounding whitespace and a
+// trailing root dot.
+func normalizeHostname(s string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+}
+
+// stripHostname removes a URL scheme (with any userinfo and path) and a
+// trailing :port from s as selected by -strip-schemes and -strip-ports.
+func stripHostname(s string, schemes, ports bool) string {
+	if schemes {
+		if i := strings.Index(s, "://"); i >= 0 {
+			s = s[i+3:]
+			if j := strings.IndexAny(s, "/?#"); j >= 0 {
+				s = s[:j]
+			}
+			if j := strings.LastIndexByte(s, '@'); j >= 0 {
+				s = s[j+1:]
+			}
+		}
+	}
+	if ports {
+		if i := strings.LastIndexByte(s, ':'); i > 0 && strings.Count(s, ":") == 1 && i < len(s)-1 &&
+			strings.Trim(s[i+1:], "0123456789") == "" {
+			s = s[:i]
+		}
+	}
+	return s
+}
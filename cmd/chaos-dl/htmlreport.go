@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportTopMatches is how many matches per program a query report shows.
+const reportTopMatches = 10
+
+// reportCell is one table cell. Sort is the key used when the column is
+// sorted, so numbers order numerically.
+type reportCell struct {
+	Text string
+	Sort string
+}
+
+// htmlReport is the data rendered by -report: a heading, a few summary
+// lines and one sortable table.
+type htmlReport struct {
+	Title     string
+	Generated string
+	Lines     []string
+	Columns   []string
+	Rows      [][]reportCell
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-top: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; cursor: pointer; user-select: none; }
+td.num { text-align: right; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">Generated {{.Generated}}</p>
+{{range .Lines}}<p>{{.}}</p>
+{{end}}<table id="t">
+<thead><tr>{{range $i, $c := .Columns}}<th onclick="sortBy({{$i}})">{{$c}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td data-sort="{{.Sort}}">{{.Text}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+<script>
+var dir = {};
+function sortBy(col) {
+  var body = document.querySelector("#t tbody");
+  var rows = Array.from(body.rows);
+  dir[col] = !dir[col];
+  rows.sort(function (a, b) {
+    var x = a.cells[col].dataset.sort, y = b.cells[col].dataset.sort;
+    var nx = parseFloat(x), ny = parseFloat(y);
+    var c = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+    return dir[col] ? c : -c;
+  });
+  rows.forEach(function (r) { body.appendChild(r); });
+}
+</script>
+</body>
+</html>
+`))
+
+func (r *htmlReport) write(path string) error {
+	r.Generated = time.Now().UTC().Format(time.RFC1123)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func textCell(s string) reportCell {
+	return reportCell{Text: s, Sort: s}
+}
+
+func numCell(n float64, text string) reportCell {
+	return reportCell{Text: text, Sort: strconv.FormatFloat(n, 'f', -1, 64)}
+}
+
+// writeHTMLReport renders the outcome of a download run.
+func (s *runSummary) writeHTMLReport(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &htmlReport{
+		Title: "chaos-dl download report",
+		Lines: []string{
+			fmt.Sprintf("%d selected, %d succeeded, %d failed in %.1fs", s.Selected, s.Success, s.Failed, s.DurationSeconds),
+			fmt.Sprintf("%s subdomains, %s downloaded", formatCount(s.TotalSubdomains), formatSize(s.TotalBytes)),
+		},
+		Columns: []string{"Program", "Status", "Subdomains", "Size", "Download", "Error"},
+	}
+	if s.Aborted {
+		r.Lines = append(r.Lines, "The run was aborted early after reaching -max-failures.")
+	}
+	for _, ps := range s.Programs {
+		r.Rows = append(r.Rows, []reportCell{
+			textCell(ps.Name),
+			textCell(ps.Status),
+			numCell(float64(ps.Subdomains), formatCount(ps.Subdomains)),
+			numCell(float64(ps.Bytes), formatSize(ps.Bytes)),
+			numCell(ps.DownloadSeconds, fmt.Sprintf("%.2fs", ps.DownloadSeconds)),
+			textCell(ps.Error),
+		})
+	}
+	return r.write(path)
+}
+
+// queryReport collects per-program match counts and the first matches
+// of a query for -report.
+type queryReport struct {
+	term   string
+	counts map[string]int
+	top    map[string][]string
+}
+
+func newQueryReport(term string) *queryReport {
+	return &queryReport{term: term, counts: make(map[string]int), top: make(map[string][]string)}
+}
+
+func (q *queryReport) add(m Match) {
+	q.counts[m.Program]++
+	if len(q.top[m.Program]) < reportTopMatches {
+		q.top[m.Program] = append(q.top[m.Program], m.Subdomain)
+	}
+}
+
+func (q *queryReport) write(path string) error {
+	programs := make([]string, 0, len(q.counts))
+	total := 0
+	for p, n := range q.counts {
+		programs = append(programs, p)
+		total += n
+	}
+	sort.Slice(programs, func(i, j int) bool {
+		if q.counts[programs[i]] != q.counts[programs[j]] {
+			return q.counts[programs[i]] > q.counts[programs[j]]
+		}
+		return programs[i] < programs[j]
+	})
+
+	r := &htmlReport{
+		Title:   "chaos-dl query report",
+		Lines:   []string{fmt.Sprintf("Query %q: %s matches in %d programs", q.term, formatCount(total), len(programs))},
+		Columns: []string{"Program", "Matches", "Top matches"},
+	}
+	for _, p := range programs {
+		r.Rows = append(r.Rows, []reportCell{
+			textCell(p),
+			numCell(float64(q.counts[p]), formatCount(q.counts[p])),
+			textCell(strings.Join(q.top[p], ", ")),
+		})
+	}
+	return r.write(path)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName lists program name patterns that are left out of
+// listings, -d all and -pick. It is read from the data directory
+// (~/.chaos-dl) and from the current directory, so a team can keep one
+// in its recon repository.
+const ignoreFileName = ".chaos-dlignore"
+
+// loadIgnorePatterns reads the glob patterns of every ignore file found.
+// Blank lines and lines starting with # are skipped.
+func loadIgnorePatterns() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	for _, dir := range []string{filepath.Dir(chaosDir), "."} {
+		p, err := filepath.Abs(filepath.Join(dir, ignoreFileName))
+		if err != nil || seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if _, err := path.Match(line, ""); err != nil {
+				fmt.Fprintf(errOut, "[!] %s: bad pattern %q\n", p, line)
+				continue
+			}
+			patterns = append(patterns, strings.ToLower(line))
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(errOut, "[!] %s: %v (patterns after it are ignored)\n", p, err)
+		}
+		f.Close()
+	}
+	return patterns
+}
+
+// applyIgnore drops the programs whose name matches an ignore pattern.
+func applyIgnore(programs []Program) []Program {
+	patterns := loadIgnorePatterns()
+	if len(patterns) == 0 {
+		return programs
+	}
+
+	var kept []Program
+	for _, p := range programs {
+		if !ignoredProgram(p.Name, patterns) {
+			kept = append(kept, p)
+		}
+	}
+	if n := len(programs) - len(kept); n > 0 {
+		fmt.Fprintf(statusOut, "[*] Ignoring %d programs (%s)\n", n, ignoreFileName)
+	}
+	return kept
+}
+
+func ignoredProgram(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// importList stores an external newline-delimited list (or "-" for
+// stdin) as chaos/<name>/subdomains.txt so it participates in queries.
+// Lines may also be JSON objects as written by subfinder (-oJ) or amass,
+// in which case the "host" or "name" field is used. "subdomain,ip,..."
+// records as printed by -resolve -show-ips keep their valid addresses for
+// -cidr. Lines that are not valid hostnames are skipped.
+func importList(src, name string) error {
+	if err := validProgramName(name); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if src != "-" {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	path := outputPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	outFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	writer := bufio.NewWriter(outFile)
+
+	seen := make(map[string]bool)
+	var imported, skipped int
+
+	scanner := newLineScanner(in)
+	for scanner.Scan() {
+		host, ips := splitRecord(importHost(scanner.Text()))
+		if opts.normalize {
+			host = normalizeHostname(host)
+		}
+		if !isValidHostname(host) {
+			if host != "" {
+				skipped++
+			}
+			continue
+		}
+		if opts.normalize {
+			if seen[host] {
+				continue
+			}
+			seen[host] = true
+		}
+		writer.WriteString(host)
+		for _, ip := range ips {
+			if _, err := netip.ParseAddr(ip); err == nil {
+				writer.WriteByte(',')
+				writer.WriteString(ip)
+			}
+		}
+		writer.WriteByte('\n')
+		imported++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	mf := loadManifest()
+	mf.update(name, imported)
+	if err := mf.save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(statusOut, "[+] Imported %d subdomains into %s (%d invalid lines skipped)\n", imported, name, skipped)
+	return nil
+}
+
+// validProgramName rejects names that cannot be used as a single
+// directory under chaos/.
+func validProgramName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid program name %q", name)
+	}
+	return nil
+}
+
+// importHost extracts the hostname from one input line.
+func importHost(line string) string {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return line
+	}
+	var rec struct {
+		Host string `json:"host"`
+		Name string `json:"name"`
+	}
+	if json.Unmarshal([]byte(line), &rec) != nil {
+		return ""
+	}
+	if rec.Host != "" {
+		return rec.Host
+	}
+	return rec.Name
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// indexMeta holds the validators of the cached index, stored next to it
+// as index.meta.json, so a refresh can ask the server whether the index
+// changed instead of downloading it again.
+type indexMeta struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Checked      time.Time `json:"checked"`
+}
+
+func indexMetaPath() string {
+	return filepath.Join(filepath.Dir(cacheFile), "index.meta.json")
+}
+
+func loadIndexMeta() indexMeta {
+	var m indexMeta
+	data, err := os.ReadFile(indexMetaPath())
+	if err != nil {
+		return m
+	}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+func (m indexMeta) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := indexMetaPath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Programs are stored either nested, as chaos/<name>/subdomains.txt, or
+// with -flat as chaos/<name>.txt. With -shard either form moves one level
+// down into a directory named after the first two characters of the
+// program, as chaos/ab/abc-program/. Readers accept every layout,
+// preferring the configured one when a program has several.
+
+// shardOf returns the -shard directory name of a program: the first two
+// characters of its lower-cased name, padded with "_".
+func shardOf(name string) string {
+	s := []rune(strings.ToLower(name) + "__")
+	return string(s[:2])
+}
+
+// programRoot returns the directory a program is written under.
+func programRoot(name string) string {
+	if opts.shard {
+		return filepath.Join(chaosDir, shardOf(name))
+	}
+	return chaosDir
+}
+
+// programDir returns the per-program directory, chaos/<name> or with
+// -shard chaos/<shard>/<name>.
+func programDir(name string) string {
+	return filepath.Join(programRoot(name), name)
+}
+
+// inShard reports whether dir is the shard directory below root that
+// program name belongs in.
+func inShard(root, dir, name string) bool {
+	return filepath.Dir(dir) == root && filepath.Base(dir) == shardOf(name)
+}
+
+// outputPath returns where a program's list is written.
+func outputPath(name string) string {
+	if opts.flat {
+		return filepath.Join(programRoot(name), name+".txt")
+	}
+	return filepath.Join(programDir(name), "subdomains.txt")
+}
+
+// subdomainsPath returns the existing list of a program, or where it
+// would be written when there is none.
+func subdomainsPath(name string) string {
+	roots := []string{chaosDir, filepath.Join(chaosDir, shardOf(name))}
+	if opts.shard {
+		roots[0], roots[1] = roots[1], roots[0]
+	}
+	for _, root := range roots {
+		nested := filepath.Join(root, name, "subdomains.txt")
+		flat := filepath.Join(root, name+".txt")
+		first, second := nested, flat
+		if opts.flat {
+			first, second = flat, nested
+		}
+		if fileExists(first) {
+			return first
+		}
+		if fileExists(second) {
+			return second
+		}
+	}
+	return outputPath(name)
+}
+
+// previousPath returns where -keep-previous keeps the list replaced by a
+// new extraction of the list at path.
+func previousPath(path string) string {
+	if filepath.Base(path) == "subdomains.txt" {
+		return filepath.Join(filepath.Dir(path), "subdomains.prev.txt")
+	}
+	return strings.TrimSuffix(path, ".txt") + ".prev.txt"
+}
+
+// flatProgram reports whether a file directly in a data directory (or
+// a shard directory) is a program list in the flat layout, and which
+// program.
+func flatProgram(fileName string) (string, bool) {
+	if strings.HasPrefix(fileName, ".") || !strings.HasSuffix(fileName, ".txt") || strings.HasSuffix(fileName, ".prev.txt") ||
+		fileName == "subdomains.txt" {
+		return "", false
+	}
+	return strings.TrimSuffix(fileName, ".txt"), true
+}
+
+// needsProgramDir reports whether a download uses chaos/<name>/, which
+// the flat layout only does for -raw, -keep-zip and -no-unzip data.
+func needsProgramDir() bool {
+	return !opts.flat || opts.raw || opts.keepZip || opts.noUnzip
+}
+
+// removeProgram deletes a program's data in every layout.
+func removeProgram(name string) error {
+	for _, root := range []string{chaosDir, filepath.Join(chaosDir, shardOf(name))} {
+		if err := os.RemoveAll(filepath.Join(root, name)); err != nil {
+			return err
+		}
+		for _, file := range []string{name + ".txt", name + ".prev.txt"} {
+			if err := os.Remove(filepath.Join(root, file)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	os.Remove(filepath.Join(chaosDir, shardOf(name))) // only if now empty
+	return nil
+}
+
+// programEntry is a directory entry that may hold a program, with the
+// directory it was read from.
+type programEntry struct {
+	dir string
+	fs.DirEntry
+}
+
+// programEntries reads a data directory laid out like chaos/, replacing
+// each -shard directory by its contents.
+func programEntries(root string) ([]programEntry, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var out []programEntry
+	for _, e := range entries {
+		dir := filepath.Join(root, e.Name())
+		if e.IsDir() && isShardDir(dir) {
+			sub, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, se := range sub {
+				out = append(out, programEntry{dir, se})
+			}
+			continue
+		}
+		out = append(out, programEntry{root, e})
+	}
+	return out, nil
+}
+
+// isShardDir reports whether dir, directly below a data directory, is a
+// -shard directory: a two-character name holding at least one program
+// that belongs there.
+func isShardDir(dir string) bool {
+	if len([]rune(filepath.Base(dir))) != 2 {
+		return false
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() {
+			var ok bool
+			if name, ok = flatProgram(name); !ok {
+				continue
+			}
+		}
+		if shardOf(name) == filepath.Base(dir) {
+			return true
+		}
+	}
+	return false
+}
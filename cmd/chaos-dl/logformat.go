@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errOut receives warnings and errors. Like statusOut it is replaced by
+// a jsonLog with -log-format json.
+var errOut io.Writer = os.Stderr
+
+// jsonLog turns the status lines written to it into one JSON object per
+// line for -log-format json. A log with a fixed level, as on statusOut,
+// uses it for every line. Otherwise the level comes from the [*]/[!]/[-]
+// prefix, and lines without one, such as the indented rows of the error
+// report, are logged at info level.
+type jsonLog struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level string
+	buf   []byte
+}
+
+func (l *jsonLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := string(l.buf[:i])
+		l.buf = l.buf[i+1:]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		level, msg := splitLevel(line)
+		if l.level != "" {
+			level = l.level
+		}
+		if err := writeLogJSON(l.w, level, msg); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// log writes one object directly, for callers that know the level and
+// have fields to attach.
+func (l *jsonLog) log(level, msg string, fields ...field) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return writeLogJSON(l.w, level, msg, fields...)
+}
+
+// jsonLogOf returns a function logging to the jsonLog behind w, looking
+// through the -progress-bar wrapper so the bars are erased first. ok is
+// false when w is not a JSON log.
+func jsonLogOf(w io.Writer) (logf func(level, msg string, fields ...field) error, ok bool) {
+	switch w := w.(type) {
+	case *jsonLog:
+		return w.log, true
+	case *progressWriter:
+		l, ok := w.w.(*jsonLog)
+		if !ok {
+			return nil, false
+		}
+		return func(level, msg string, fields ...field) error {
+			w.d.mu.Lock()
+			defer w.d.mu.Unlock()
+			w.d.erase()
+			return l.log(level, msg, fields...)
+		}, true
+	}
+	return nil, false
+}
+
+var logPrefixes = []struct{ prefix, level string }{
+	{"[*] ", "info"},
+	{"[+] ", "info"},
+	{"[=] ", "info"},
+	{"[!] ", "warn"},
+	{"[-] ", "error"},
+}
+
+// splitLevel maps a status prefix to a log level and strips it.
+func splitLevel(line string) (string, string) {
+	for _, p := range logPrefixes {
+		if msg, ok := strings.CutPrefix(line, p.prefix); ok {
+			return p.level, msg
+		}
+	}
+	return "info", strings.TrimSpace(line)
+}
+
+// writeLogJSON writes one log object: timestamp, level and message,
+// followed by fields in order.
+func writeLogJSON(w io.Writer, level, msg string, fields ...field) error {
+	rec := record{Fields: append([]field{
+		{"time", time.Now().UTC().Format(time.RFC3339Nano)},
+		{"level", level},
+		{"msg", msg},
+	}, fields...)}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// statusLevelName is the -log-format json level of a status line.
+func statusLevelName(level statusLevel) string {
+	switch level {
+	case levelWarning:
+		return "warn"
+	case levelError:
+		return "error"
+	}
+	return "info"
+}
+
+// setLogFormat applies -log-format, wrapping statusOut and errOut.
+func setLogFormat(format string) error {
+	switch format {
+	case "text":
+	case "json":
+		// statusOut's "[-]" lines report removals, not failures.
+		statusOut = &jsonLog{w: statusOut, level: "info"}
+		errOut = &jsonLog{w: errOut}
+	default:
+		return fmt.Errorf("unknown -log-format '%s' (want text or json)", format)
+	}
+	return nil
+}
@@ -3,16 +3,25 @@ package main
 import (
 	"archive/zip"
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -20,100 +29,707 @@ const (
 )
 
 var (
-	cacheFile string
-	chaosDir  string
+	cacheFile  string
+	chaosDir   string
+	bloomFile  string
+	historyDir string
+	failedDir  string
+	opts       options
+	where      func(string) bool
+	httpClient = http.DefaultClient
+
+	// statusOut receives human progress lines. It is stderr when -format
+	// selects a machine-readable format so stdout stays parseable.
+	statusOut io.Writer = os.Stdout
 )
 
+// options holds the settings that tune individual modes. Flags bind
+// directly to its fields in main.
+type options struct {
+	resolve          bool
+	resolver         string
+	resolveTimeout   time.Duration
+	indexTimeout     time.Duration
+	showIPs          bool
+	order            string
+	check            bool
+	format           string
+	pin              string
+	raw              bool
+	shrinkPercent    float64
+	importName       string
+	normalize        bool
+	all              bool
+	head             int
+	tail             int
+	ci               string
+	cacheLimit       int64
+	yes              bool
+	saveHistory      bool
+	historyRetention int
+	keepPrevious     bool
+	maxLine          int
+	dnsCacheTTL      time.Duration
+	h2Ping           time.Duration
+	events           string
+	pick             bool
+	keepZip          bool
+	maxFailures      int
+	retryBudget      time.Duration
+	summaryJSON      string
+	wildcards        string
+	tmpDir           string
+	stripSchemes     bool
+	stripPorts       bool
+	in               string
+	autoFetch        bool
+	verbose          bool
+	minLabels        int
+	maxLabels        int
+	quietSuccess     bool
+	noUnzip          bool
+	printFormat      string
+	platform         string
+	tag              string
+	overwrite        bool
+	report           string
+	writeBuf         int
+	ioWorkers        int
+	allowInsecure    bool
+	resumeRun        bool
+	color            bool
+	maxUncompressed  int64
+	long             bool
+	logic            string
+	flat             bool
+	jitter           time.Duration
+	minMatches       int
+	tee              string
+	ordered          bool
+	maxInflight      int64
+	fix              bool
+	printURL         bool
+	deadAfter        int
+	retryDead        bool
+	full             bool
+	groupByDomain    bool
+	refreshOnMiss    bool
+	progressBar      bool
+	dedupAcross      string
+	verifyExtract    bool
+	shard            bool
+	keepFailedZip    bool
+	countDistinct    bool
+	labelsOnly       bool
+	out              string
+	sample           int
+}
+
 func init() {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		home = "."
 	}
-	baseDir := filepath.Join(home, ".chaos-dl")
+	setBaseDir(filepath.Join(home, ".chaos-dl"))
+}
+
+// stringList is a flag that may be repeated, collecting every value.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// setBaseDir points every data path at baseDir, creating it.
+func setBaseDir(baseDir string) {
 	os.MkdirAll(baseDir, 0755)
 	cacheFile = filepath.Join(baseDir, "index.json")
 	chaosDir = filepath.Join(baseDir, "chaos")
+	bloomFile = filepath.Join(baseDir, "bloom.bin")
+	historyDir = filepath.Join(baseDir, "history")
+	failedDir = filepath.Join(baseDir, "failed")
 }
 
 type Program struct {
-	Name  string `json:"name"`
-	URL   string `json:"URL"`
-	Count int    `json:"count"`
+	Name     string   `json:"name"`
+	URL      string   `json:"URL"`
+	Count    int      `json:"count"`
+	Platform string   `json:"platform,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
 }
 
 type downloadResult struct {
-	program Program
-	zipPath string
-	err     error
+	program  Program
+	zipPath  string
+	bytes    int64
+	duration time.Duration
+	err      error
 }
 
 type unzipJob struct {
-	program Program
-	zipPath string
-}
-
-type queryResult struct {
-	file       string
-	matchCount int
+	program  Program
+	zipPath  string
+	bytes    int64
+	duration time.Duration
 }
 
 func main() {
 	refresh := flag.Bool("u", false, "Update the index.json cache")
 	download := flag.String("d", "", "Download subdomains for a specific program (or 'all')")
-	query := flag.String("q", "", "Query for a domain across all downloaded data")
+	var query stringList
+	flag.Var(&query, "q", "Query for a domain across all downloaded data (repeat for several terms, see -logic)")
+	flag.StringVar(&opts.out, "out", "", "With -union or -labels-only, write the list to this file instead of stdout")
+	flag.StringVar(&opts.tee, "tee", "", "Also write query results to this file while printing them")
+	flag.IntVar(&opts.minMatches, "min-matches", 0, "Only output query results from programs with at least this many matching lines")
+	flag.StringVar(&opts.logic, "logic", "and", "How repeated -q terms combine per line: and or or")
+	cidr := flag.String("cidr", "", "Query stored subdomain,ip records for addresses inside a CIDR (comma-separated ranges or IPs)")
+	members := flag.String("members", "", "Report which hostnames in a file (or '-' for stdin) are stored, and in which programs")
+	exists := flag.String("exists", "", "Check whether an exact subdomain exists anywhere in downloaded data")
+	importFile := flag.String("import", "", "Import a subdomain list (file or '-' for stdin) as program -name")
+	diffSubs := flag.String("diff-subs", "", "Show subdomains added/removed in a program since its previous download")
+	listLocal := flag.Bool("list-downloaded", false, "List programs with local data, without reading the index")
+	flag.BoolVar(&opts.long, "long", false, "With -list-downloaded, also show subdomain counts, size and last update")
+	history := flag.String("history", "", "Show a program's timeline across archived index snapshots, or the programs listed on a date (YYYY-MM-DD)")
+	compare := flag.String("compare", "", "Compare this data directory with the one given as argument, per program")
+	directURL := flag.String("url", "", "Download and extract the archive at this URL without the index (name it with -name)")
+	reextractFailed := flag.Bool("reextract", false, "Extract the archives kept by -keep-failed-zip again, without downloading")
+	union := flag.Bool("union", false, "Print the deduplicated union of the programs (names or globs) given as arguments")
+	mergeInto := flag.String("merge-into", "", "Merge the programs given as arguments into a new program with this name")
+	validate := flag.Bool("validate", false, "Check downloaded data for empty, unreadable or malformed subdomain files")
 	list := flag.Bool("l", false, "List all available programs")
-	workers := flag.Int("w", runtime.NumCPU()*2, "Number of concurrent workers")
+	workers := flag.Int("w", runtime.NumCPU()*2, "Number of concurrent download workers")
+	queryWorkers := flag.Int("qw", runtime.NumCPU(), "Number of concurrent workers scanning local data for -q, -cidr and -members")
+	flag.BoolVar(&opts.resolve, "resolve", false, "Only output query results that resolve in DNS")
+	flag.StringVar(&opts.resolver, "resolver", "", "DNS server (host[:port]) used by -resolve instead of the system resolver")
+	flag.DurationVar(&opts.indexTimeout, "index-timeout", time.Minute, "Give up fetching the index after this long (0 waits indefinitely); downloads are not affected")
+	flag.BoolVar(&opts.refreshOnMiss, "refresh-on-miss", false, "When -d names a program missing from the cached index, refresh the index once and look again")
+	flag.DurationVar(&opts.resolveTimeout, "resolve-timeout", 2*time.Second, "Timeout for each DNS lookup made by -resolve")
+	flag.BoolVar(&opts.showIPs, "show-ips", false, "With -resolve, append resolved IPs to each line (subdomain,ip,...)")
+	flag.StringVar(&opts.order, "order", "index", "Download order: largest, smallest, index or random")
+	flag.BoolVar(&opts.check, "check", false, "Diagnose connectivity to the index URL (or the URL given as argument) and exit")
+	flag.StringVar(&opts.format, "format", "text", "Output format for -l, -q and the download summary: text, json, jsonl or csv; hosts writes /etc/hosts lines for -q -resolve")
+	flag.StringVar(&opts.pin, "pin", "", "Require the server's public key SHA-256 (hex or base64, comma-separated) for index and downloads")
+	flag.BoolVar(&opts.raw, "raw", false, "Extract every archive entry as-is under the program directory instead of merging .txt files")
+	flag.Float64Var(&opts.shrinkPercent, "shrink-threshold", 20, "Warn when a program loses more than this percent of its subdomains since the last sync")
+	flag.StringVar(&opts.importName, "name", "", "Program name used by -import and -url")
+	flag.BoolVar(&opts.normalize, "normalize", false, "With -import, lowercase, strip trailing dots and dedup lines")
+	flag.BoolVar(&opts.countDistinct, "count-distinct", false, "With -q, print the number of distinct matching subdomains per program and overall instead of the matches")
+	flag.BoolVar(&opts.labelsOnly, "labels-only", false, "With -q, print the distinct leftmost labels of the matches as a wordlist instead of the matches")
+	flag.BoolVar(&opts.all, "all", false, "With -q, print matching lines from every program instead of the best-matching program's file")
+	flag.IntVar(&opts.head, "head", 0, "Only output the first N query results")
+	flag.IntVar(&opts.tail, "tail", 0, "Only output the last N query results")
+	flag.StringVar(&opts.ci, "ci", detectCI(), "CI log style: github groups per-program output and annotates failures (auto-detected)")
+	cacheLimit := flag.String("cache-limit", "", "Evict least recently queried programs once extracted data exceeds this size (e.g. 50G)")
+	flag.BoolVar(&opts.yes, "yes", false, "Do not ask for confirmation before evicting or deleting data")
+	flag.IntVar(&opts.minLabels, "min-labels", 0, "Only output query results with at least this many dot-separated labels (e.g. 4 for a.b.example.com)")
+	flag.IntVar(&opts.maxLabels, "max-labels", 0, "Only output query results with at most this many dot-separated labels (0 for no limit)")
+	whereExpr := flag.String("where", "", "Only output query results matching an expression over hostname parts (e.g. 'labels > 3 && tld == \"internal\"')")
+	flag.BoolVar(&opts.saveHistory, "save-index-history", false, "Archive each fetched index as history/index-YYYYMMDD.json.gz")
+	flag.IntVar(&opts.historyRetention, "history-retention", 0, "Delete index snapshots older than this many days (0 keeps all)")
+	flag.BoolVar(&opts.keepFailedZip, "keep-failed-zip", false, "Keep archives that fail to extract in failed/ for -reextract instead of deleting them")
+	flag.BoolVar(&opts.keepPrevious, "keep-previous", false, "Keep the previous subdomains.txt as subdomains.prev.txt for -diff-subs")
+	maxLine := flag.String("max-line", "1M", "Longest line accepted when scanning subdomain files")
+	flag.DurationVar(&opts.dnsCacheTTL, "dns-cache-ttl", 0, "Cache download host lookups for this long (0 disables the cache)")
+	flag.DurationVar(&opts.h2Ping, "h2-ping", 30*time.Second, "Ping HTTP/2 connections idle this long and replace ones that do not answer (advanced; 0 disables)")
+	flag.StringVar(&opts.events, "events", "", "Stream JSON progress events to a file path or an inherited file descriptor number")
+	flag.BoolVar(&opts.pick, "pick", false, "Interactively pick programs to download (reads names from stdin when not a terminal)")
+	purgeCache := flag.Bool("purge-index-cache", false, "Delete the cached index, its validators, the bloom filter and index snapshots (not downloaded data), then exit")
+	mirror := flag.Bool("mirror", false, "Keep a complete mirror: download every program with -keep-zip, conditionally on later runs, and write per-program SHA256SUMS")
+	flag.BoolVar(&opts.keepZip, "keep-zip", false, "Keep each archive as chaos/<name>/source.zip and skip extraction when it is unchanged")
+	flag.IntVar(&opts.maxFailures, "max-failures", 0, "Abort the download run after this many failed downloads (0 never aborts)")
+	flag.DurationVar(&opts.retryBudget, "timeout-retry-budget", 0, "Give up retrying a program's download once this long has passed since its first failed attempt (0 leaves only the attempt limit)")
+	flag.StringVar(&opts.summaryJSON, "summary-json", "", "Write a machine-readable summary of the download run to this file")
+	flag.StringVar(&opts.wildcards, "wildcards", "match", "How stored *.domain lines match queries: match, ignore or expand")
+	flag.StringVar(&opts.tmpDir, "tmp", "", "Directory for partial downloads (default $TMPDIR); use the data volume to avoid filling a small tmpfs")
+	flag.BoolVar(&opts.stripSchemes, "strip-schemes", false, "Strip URL schemes (and paths) from entries while extracting, e.g. https://a.example.com/x")
+	flag.BoolVar(&opts.stripPorts, "strip-ports", false, "Strip :port suffixes from entries while extracting")
+	flag.StringVar(&opts.in, "in", "", "With -q, only search this program")
+	flag.BoolVar(&opts.autoFetch, "auto-fetch", false, "With -q -in, download the program first if it is not present locally")
+	logFormat := flag.String("log-format", "text", "Status and error message format: text, or json for one object per line with time, level, msg and fields")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Print per-program download size and throughput, and the slowest downloads")
+	flag.BoolVar(&opts.quietSuccess, "quiet-success", false, "Do not print a line per program that downloaded successfully; failures, skips and the summary are still printed")
+	flag.BoolVar(&opts.noUnzip, "no-unzip", false, "Only download archives to chaos/<name>/source.zip, skipping extraction")
+	flag.StringVar(&opts.printFormat, "print-format", "", "Template for text query output with {program}, {subdomain}, {lineno} and {ips} (e.g. '{program}\\t{subdomain}')")
+	flag.StringVar(&opts.platform, "platform", "", "Only list or download programs on these platforms (comma-separated, e.g. hackerone)")
+	flag.StringVar(&opts.tag, "tag", "", "Only list or download programs with one of these tags (comma-separated)")
+	flag.BoolVar(&opts.overwrite, "overwrite", false, "Re-download programs that already have local data instead of skipping them")
+	flag.BoolVar(&opts.overwrite, "force", false, "Alias for -overwrite")
+	flag.StringVar(&opts.report, "report", "", "Write a self-contained HTML report of the download or query run to this file")
+	colorMode := flag.String("color", "auto", "Highlight query matches: auto (terminal and no NO_COLOR), always or never")
+	flag.StringVar(&opts.dedupAcross, "dedup-across-programs", "", "Also write every subdomain of the run once to this file, tab-separated from the first program it was seen in")
+	flag.BoolVar(&opts.progressBar, "progress-bar", false, "Show a live progress bar per active download (plain periodic lines when not a terminal)")
+	flag.BoolVar(&opts.ordered, "ordered", false, "Print per-program download status in input order instead of completion order")
+	flag.DurationVar(&opts.jitter, "jitter", 500*time.Millisecond, "Delay each download worker's first request by a random duration up to this (0 disables)")
+	flag.BoolVar(&opts.shard, "shard", false, "Nest programs under a directory named after their first two characters, as chaos/ab/abc-program/")
+	flag.BoolVar(&opts.flat, "flat", false, "Store each program's list as chaos/<name>.txt instead of chaos/<name>/subdomains.txt")
+	flag.BoolVar(&opts.resumeRun, "resume-run", false, "Continue an interrupted -d run, skipping the programs it already finished")
+	flag.BoolVar(&opts.allowInsecure, "allow-insecure-http", false, "Allow downloading program archives over plain HTTP (warns for each)")
+	flag.IntVar(&opts.ioWorkers, "workers-io", 0, "Hand extraction writes to this many dedicated disk writer goroutines (0 writes from the unzip workers)")
+	maxUncompressed := flag.String("max-uncompressed", "10G", "Abort extracting a program whose archive decompresses to more than this (0 disables)")
+	maxInflight := flag.String("max-programs-in-flight-bytes", "", "Pause downloads while archives waiting for extraction exceed this size (e.g. 2G)")
+	writeBuf := flag.String("write-buf", "256K", "Write buffer size used when extracting archives")
+	flag.BoolVar(&opts.verifyExtract, "verify-extract", false, "After extracting, check the list's line count against the archive entries and extract again on a mismatch")
+	flag.IntVar(&opts.sample, "sample", 0, "Only extract the first N subdomains of each program (0 extracts everything); sampled lists are marked in the manifest")
+	flag.BoolVar(&opts.fix, "fix", false, "With -validate, re-download programs that fail validation")
+	flag.BoolVar(&opts.printURL, "print-url", false, "With -d, print name<TAB>URL for the selected programs instead of downloading")
+	flag.IntVar(&opts.deadAfter, "dead-after", 3, "Skip programs in -d all once their download returned 4xx this many runs in a row (0 never skips)")
+	flag.BoolVar(&opts.retryDead, "retry-dead", false, "Attempt programs skipped by -dead-after anyway")
+	flag.BoolVar(&opts.groupByDomain, "group-by-domain", false, "Sort query output by registrable domain (eTLD+1) and group it under a header per domain")
+	flag.BoolVar(&opts.full, "full", false, "With -compare, print every added and removed subdomain instead of counts")
+	flag.String("config", "", "Read option defaults from this YAML file (default ~/.config/chaos-dl/config.yaml)")
+	explain := flag.Bool("explain", false, "Print the effective settings and where each came from (flag, env, config or default), then exit")
+	if err := applyConfig(); err != nil {
+		fmt.Fprintf(errOut, "[-] Config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := applyEnv(); err != nil {
+		fmt.Fprintf(errOut, "[-] %v\n", err)
+		os.Exit(1)
+	}
 	flag.Parse()
+	markCommandLine()
 
-	if *refresh || !fileExists(cacheFile) {
-		fmt.Println("[*] Fetching index.json...")
-		if err := fetchIndex(); err != nil {
-			fmt.Fprintf(os.Stderr, "[-] Error fetching index: %v\n", err)
+	if !validFormat(opts.format) {
+		fmt.Fprintf(errOut, "[-] Unknown format '%s'\n", opts.format)
+		os.Exit(1)
+	}
+	if opts.format == "hosts" {
+		if len(query) == 0 || !opts.resolve || opts.countDistinct || opts.labelsOnly {
+			fmt.Fprintln(errOut, "[-] -format hosts only applies to -q with -resolve")
+			os.Exit(1)
+		}
+		// The addresses come from the ips field -show-ips adds.
+		opts.showIPs = true
+	}
+	if *cacheLimit != "" {
+		limit, err := parseSize(*cacheLimit)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] -cache-limit: %v\n", err)
+			os.Exit(1)
+		}
+		opts.cacheLimit = limit
+	}
+	if n, err := parseSize(*maxLine); err != nil || n < 1 {
+		fmt.Fprintf(errOut, "[-] Invalid -max-line '%s'\n", *maxLine)
+		os.Exit(1)
+	} else {
+		opts.maxLine = int(n)
+	}
+	if n, err := parseSize(*maxUncompressed); err != nil {
+		fmt.Fprintf(errOut, "[-] Invalid -max-uncompressed '%s'\n", *maxUncompressed)
+		os.Exit(1)
+	} else {
+		opts.maxUncompressed = n
+	}
+	if *maxInflight != "" {
+		n, err := parseSize(*maxInflight)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] -max-programs-in-flight-bytes: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("[+] Index cached")
+		opts.maxInflight = n
+	}
+	if n, err := parseSize(*writeBuf); err != nil || n < 1 {
+		fmt.Fprintf(errOut, "[-] Invalid -write-buf '%s'\n", *writeBuf)
+		os.Exit(1)
+	} else {
+		opts.writeBuf = int(n)
+	}
+	if on, err := useColor(*colorMode); err != nil {
+		fmt.Fprintf(errOut, "[-] %v\n", err)
+		os.Exit(1)
+	} else {
+		opts.color = on
+	}
+	if opts.logic != "and" && opts.logic != "or" {
+		fmt.Fprintf(errOut, "[-] Unknown -logic '%s' (want and or or)\n", opts.logic)
+		os.Exit(1)
+	}
+	if *whereExpr != "" {
+		pred, err := compileWhere(*whereExpr)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] -where: %v\n", err)
+			os.Exit(1)
+		}
+		where = pred
+	}
+	if opts.minLabels < 0 || opts.maxLabels < 0 || opts.maxLabels > 0 && opts.minLabels > opts.maxLabels {
+		fmt.Fprintf(errOut, "[-] Invalid label range: -min-labels %d -max-labels %d\n", opts.minLabels, opts.maxLabels)
+		os.Exit(1)
+	}
+	if err := validWildcardPolicy(opts.wildcards); err != nil {
+		fmt.Fprintf(errOut, "[-] %v\n", err)
+		os.Exit(1)
+	}
+	if opts.tmpDir != "" {
+		if err := os.MkdirAll(opts.tmpDir, 0755); err != nil {
+			fmt.Fprintf(errOut, "[-] -tmp: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := validateLimits(); err != nil {
+		fmt.Fprintf(errOut, "[-] %v\n", err)
+		os.Exit(1)
 	}
+	*workers = capWorkers("w", *workers)
+	*queryWorkers = capWorkers("qw", *queryWorkers)
+	if opts.format != "text" {
+		statusOut = os.Stderr
+	}
+	if err := setLogFormat(*logFormat); err != nil {
+		fmt.Fprintf(errOut, "[-] %v\n", err)
+		os.Exit(1)
+	}
+	opts.printFormat = printFormatEscapes.Replace(opts.printFormat)
 
-	programs, err := loadIndex()
+	client, err := newHTTPClient()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "[-] Error loading index: %v\n", err)
+		fmt.Fprintf(errOut, "[-] %v\n", err)
 		os.Exit(1)
 	}
+	httpClient = client
+
+	if *explain {
+		if err := explainConfig(os.Stdout); err != nil {
+			fmt.Fprintf(errOut, "[-] %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.events != "" {
+		if err := openEvents(opts.events); err != nil {
+			fmt.Fprintf(errOut, "[-] Open events: %v\n", err)
+			os.Exit(1)
+		}
+		defer closeEvents()
+	}
+	if opts.pin != "" {
+		fmt.Fprintln(errOut, "[!] Certificate pinning enabled: connections to hosts with other keys will fail")
+	}
+
+	if *purgeCache {
+		if err := purgeIndexCache(); err != nil {
+			fmt.Fprintf(errOut, "[-] Purge: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *listLocal {
+		if err := listDownloaded(); err != nil {
+			fmt.Fprintf(errOut, "[-] %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *reextractFailed {
+		failed, err := reextract()
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] Re-extract: %v\n", err)
+			os.Exit(1)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *union {
+		if err := unionPrograms(flag.Args()); err != nil {
+			fmt.Fprintf(errOut, "[-] Union: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *directURL != "" {
+		p, err := directProgram(*directURL, opts.importName)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] -url: %v\n", err)
+			os.Exit(1)
+		}
+		parallelDownload([]Program{p}, 1)
+		return
+	}
+
+	if opts.check {
+		target := indexURL
+		if flag.NArg() > 0 {
+			target = flag.Arg(0)
+		}
+		if err := runCheck(target); err != nil {
+			fmt.Fprintf(errOut, "[-] Check failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fetched := *refresh || *mirror || !fileExists(cacheFile)
+	if fetched {
+		refreshIndex()
+	}
+	programs := loadPrograms()
+	if *download != "" && opts.refreshOnMiss && !fetched && !hasProgram(programs, *download) {
+		fmt.Fprintf(statusOut, "[*] Program '%s' not in the cached index, refreshing...\n", *download)
+		refreshIndex()
+		programs = loadPrograms()
+	}
+	if (*download != "" || opts.pick) && !fetched && indexExpired(programs) {
+		fmt.Fprintln(statusOut, "[*] Download URLs in the cached index have expired, refreshing...")
+		refreshIndex()
+		programs = loadPrograms()
+	}
 
 	switch {
 	case *list:
-		for _, p := range programs {
-			fmt.Println(p.Name)
+		listPrograms(applyIgnore(programs))
+	case *mirror:
+		if err := mirrorAll(programs, *workers); err != nil {
+			fmt.Fprintf(errOut, "[-] %v\n", err)
+			os.Exit(1)
 		}
+	case *download != "" && opts.printURL:
+		printURLs(selectPrograms(programs, *download))
 	case *download != "":
-		parallelDownload(programs, *download, *workers)
-	case *query != "":
-		parallelQuery(*query, *workers)
+		parallelDownload(selectPrograms(programs, *download), *workers)
+		if opts.cacheLimit > 0 {
+			if err := enforceCacheLimit(opts.cacheLimit); err != nil {
+				fmt.Fprintf(errOut, "[-] Cache limit: %v\n", err)
+			}
+		}
+	case opts.pick:
+		selected, err := pickPrograms(applyIgnore(programs))
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] Pick: %v\n", err)
+			os.Exit(1)
+		}
+		if len(selected) == 0 {
+			fmt.Fprintln(statusOut, "[*] Nothing selected")
+			return
+		}
+		parallelDownload(selected, *workers)
+	case len(query) > 0:
+		if opts.autoFetch && opts.in != "" {
+			name, err := ensureProgram(programs, opts.in)
+			if err != nil {
+				fmt.Fprintf(errOut, "[-] Auto-fetch: %v\n", err)
+				os.Exit(1)
+			}
+			opts.in = name
+		}
+		if opts.in != "" && !fileExists(subdomainsPath(opts.in)) {
+			fmt.Fprintf(errOut, "[-] Program '%s' is not downloaded (use -auto-fetch)\n", opts.in)
+			os.Exit(1)
+		}
+		parallelQuery(query, *queryWorkers)
+	case *cidr != "":
+		if err := cidrQuery(*cidr, *queryWorkers); err != nil {
+			fmt.Fprintf(errOut, "[-] -cidr: %v\n", err)
+			os.Exit(1)
+		}
+	case *members != "":
+		if err := membersQuery(*members, *queryWorkers); err != nil {
+			fmt.Fprintf(errOut, "[-] -members: %v\n", err)
+			os.Exit(1)
+		}
+	case *exists != "":
+		if !existsQuery(*exists) {
+			os.Exit(1)
+		}
+	case *validate:
+		bad, err := validateData()
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] Validate: %v\n", err)
+			os.Exit(1)
+		}
+		if opts.fix && len(bad) > 0 {
+			if failed := fixPrograms(programs, bad, *workers); failed > 0 {
+				fmt.Fprintf(errOut, "[-] %d programs could not be fixed\n", failed)
+				os.Exit(1)
+			}
+		} else if len(bad) > 0 {
+			os.Exit(1)
+		}
+	case *diffSubs != "":
+		if err := diffSubdomains(*diffSubs); err != nil {
+			fmt.Fprintf(errOut, "[-] Diff: %v\n", err)
+			os.Exit(1)
+		}
+	case *history != "":
+		if err := historyQuery(*history); err != nil {
+			fmt.Fprintf(errOut, "[-] %v\n", err)
+			os.Exit(1)
+		}
+	case *compare != "":
+		if flag.NArg() != 1 {
+			fmt.Fprintln(errOut, "[-] Usage: -compare <dirA> <dirB>")
+			os.Exit(1)
+		}
+		if err := compareSnapshots(*compare, flag.Arg(0)); err != nil {
+			fmt.Fprintf(errOut, "[-] Compare: %v\n", err)
+			os.Exit(1)
+		}
+	case *mergeInto != "":
+		if err := mergePrograms(*mergeInto, flag.Args()); err != nil {
+			fmt.Fprintf(errOut, "[-] Merge: %v\n", err)
+			os.Exit(1)
+		}
+	case *importFile != "":
+		if err := importList(*importFile, opts.importName); err != nil {
+			fmt.Fprintf(errOut, "[-] Import: %v\n", err)
+			os.Exit(1)
+		}
+	case *refresh:
+		// -u on its own only warms the index cache, fetched above.
 	default:
 		flag.Usage()
 	}
 }
 
+// refreshIndex fetches the index, exiting on failure.
+func refreshIndex() {
+	fmt.Fprintln(statusOut, "[*] Fetching index.json...")
+	changed, err := fetchIndex()
+	if err != nil {
+		fmt.Fprintf(errOut, "[-] Error fetching index: %v\n", err)
+		os.Exit(1)
+	}
+	if changed {
+		fmt.Fprintln(statusOut, "[+] Index cached")
+	} else {
+		fmt.Fprintln(statusOut, "[=] Index unchanged")
+	}
+	if opts.saveHistory {
+		if err := saveIndexHistory(); err != nil {
+			fmt.Fprintf(errOut, "[-] Save index history: %v\n", err)
+		}
+	}
+}
+
+// loadPrograms reads the cached index with -platform and -tag applied,
+// exiting on failure.
+func loadPrograms() []Program {
+	programs, err := loadIndex()
+	if err != nil {
+		fmt.Fprintf(errOut, "[-] Error loading index: %v\n", err)
+		os.Exit(1)
+	}
+	return filterPrograms(programs)
+}
+
+func listPrograms(programs []Program) {
+	out, _ := newFormatter(opts.format, os.Stdout)
+	for _, p := range programs {
+		out.Write(record{
+			Text: p.Name,
+			Fields: []field{
+				{"name", p.Name},
+				{"url", p.URL},
+				{"count", p.Count},
+				{"platform", p.Platform},
+			},
+		})
+	}
+	out.Close()
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
 
-func fetchIndex() error {
-	resp, err := http.Get(indexURL)
+// fetchIndex refreshes the cached index. When the cache exists the
+// request is conditional on its ETag/Last-Modified, and a 304 keeps the
+// cache, touching it and returning false.
+func fetchIndex() (bool, error) {
+	ctx := context.Background()
+	if opts.indexTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.indexTimeout)
+		defer cancel()
+	}
+	changed, err := fetchIndexContext(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return false, fmt.Errorf("no complete index within %s (-index-timeout)", opts.indexTimeout)
+	}
+	return changed, err
+}
+
+func fetchIndexContext(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", indexURL, nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	meta := loadIndexMeta()
+	if fileExists(cacheFile) {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
 	}
 	defer resp.Body.Close()
 
-	f, err := os.Create(cacheFile)
+	meta.Checked = time.Now().UTC()
+	if resp.StatusCode == http.StatusNotModified {
+		os.Chtimes(cacheFile, meta.Checked, meta.Checked)
+		return false, meta.save()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	body, err := maybeGunzip(resp.Body)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer f.Close()
 
-	_, err = io.Copy(f, resp.Body)
-	return err
+	tmp := cacheFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	if err := os.Rename(tmp, cacheFile); err != nil {
+		return false, err
+	}
+
+	meta.ETag = resp.Header.Get("ETag")
+	meta.LastModified = resp.Header.Get("Last-Modified")
+	return true, meta.save()
+}
+
+// maybeGunzip returns r decompressed if it starts with the gzip magic
+// bytes, so a gzipped index (index.json.gz, or Content-Encoding the
+// transport did not undo) is stored as plain JSON.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return br, nil
+	}
+	return gzip.NewReader(br)
 }
 
 func loadIndex() ([]Program, error) {
@@ -121,6 +737,15 @@ func loadIndex() ([]Program, error) {
 	if err != nil {
 		return nil, err
 	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
 
 	var programs []Program
 	if err := json.Unmarshal(data, &programs); err != nil {
@@ -129,11 +754,47 @@ func loadIndex() ([]Program, error) {
 	return programs, nil
 }
 
-func parallelDownload(programs []Program, target string, workers int) {
+// printURLs writes the download URL of each program for -print-url, in
+// the order a download would use.
+func printURLs(programs []Program) {
+	if err := orderPrograms(programs, opts.order); err != nil {
+		fmt.Fprintf(errOut, "[-] %v\n", err)
+		os.Exit(1)
+	}
+	out, _ := newFormatter(opts.format, os.Stdout)
+	for _, p := range programs {
+		if p.URL == "" || p.Count == 0 {
+			continue
+		}
+		out.Write(record{
+			Text:   p.Name + "\t" + p.URL,
+			Fields: []field{{"name", p.Name}, {"url", p.URL}},
+		})
+	}
+	out.Close()
+}
+
+// hasProgram reports whether target is "all" or names a program in
+// programs.
+func hasProgram(programs []Program, target string) bool {
+	if target == "all" {
+		return true
+	}
+	for _, p := range programs {
+		if strings.EqualFold(p.Name, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPrograms returns the programs named by target, or all of them
+// for "all".
+func selectPrograms(programs []Program, target string) []Program {
 	var toDownload []Program
 
 	if target == "all" {
-		toDownload = programs
+		toDownload = append(toDownload, skipDead(applyIgnore(programs))...)
 	} else {
 		for _, p := range programs {
 			if strings.EqualFold(p.Name, target) {
@@ -142,18 +803,77 @@ func parallelDownload(programs []Program, target string, workers int) {
 			}
 		}
 		if len(toDownload) == 0 {
-			fmt.Fprintf(os.Stderr, "[-] Program '%s' not found\n", target)
+			fmt.Fprintf(errOut, "[-] Program '%s' not found\n", target)
 			os.Exit(1)
 		}
 	}
+	return toDownload
+}
+
+// parallelDownload downloads and extracts toDownload with workers
+// workers and returns how many programs failed.
+func parallelDownload(toDownload []Program, workers int) int {
+	if err := orderPrograms(toDownload, opts.order); err != nil {
+		fmt.Fprintf(errOut, "[-] %v\n", err)
+		os.Exit(1)
+	}
 
 	os.MkdirAll(chaosDir, 0755)
 
+	summary := newRunSummary(len(toDownload))
+	selected := len(toDownload)
+	if !opts.overwrite {
+		toDownload = skipExisting(toDownload, summary)
+	}
+	skipped := selected - len(toDownload)
+	state := loadRunState()
+	toDownload = state.skipCompleted(toDownload, summary)
+	resumed := selected - skipped - len(toDownload)
+
 	// Stage 1: Parallel downloads
-	fmt.Printf("[*] Downloading %d programs with %d workers...\n", len(toDownload), workers)
+	fmt.Fprintf(statusOut, "[*] Downloading %d programs with %d workers...\n", len(toDownload), workers)
+	var global *globalDedup
+	if opts.dedupAcross != "" {
+		g, err := openGlobalDedup(opts.dedupAcross)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] -dedup-across-programs: %v\n", err)
+			os.Exit(1)
+		}
+		global = g
+	}
+	addGlobal := func(name string) {
+		if global == nil || opts.raw {
+			return
+		}
+		if err := global.add(name, subdomainsPath(name)); err != nil {
+			reportProgram(name, errorf("[-] Dedup %s: %v", name, err))
+		}
+	}
+	var indexed *bloomUpdate
+	if !opts.raw && !opts.noUnzip {
+		indexed = openBloomUpdate()
+	}
+	kept := &failedArchives{}
+	if opts.progressBar {
+		startProgress(statusOut)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A full disk fails every later program the same way, so the first
+	// ENOSPC stops the run instead.
+	var diskFull atomic.Bool
+	checkDiskFull := func(err error) {
+		if isDiskFull(err) && diskFull.CompareAndSwap(false, true) {
+			cancel()
+			fmt.Fprintf(errOut, "[-] Disk full (%v), stopping the run\n", err)
+		}
+	}
 
 	downloadJobs := make(chan Program, len(toDownload))
 	downloadResults := make(chan downloadResult, len(toDownload))
+	inflight := newInflightBytes(opts.maxInflight)
 
 	// Start download workers
 	var dlWg sync.WaitGroup
@@ -161,19 +881,43 @@ func parallelDownload(programs []Program, target string, workers int) {
 		dlWg.Add(1)
 		go func() {
 			defer dlWg.Done()
+			// Stagger the first requests so the workers do not all hit
+			// the CDN at the same instant.
+			if opts.jitter > 0 {
+				select {
+				case <-time.After(rand.N(opts.jitter)):
+				case <-ctx.Done():
+				}
+			}
 			for p := range downloadJobs {
-				zipPath, err := downloadZip(p)
-				downloadResults <- downloadResult{program: p, zipPath: zipPath, err: err}
+				if ctx.Err() != nil {
+					continue
+				}
+				inflight.wait()
+				start := time.Now()
+				zipPath, n, err := downloadZip(ctx, p)
+				if err == nil {
+					inflight.add(n)
+				}
+				downloadResults <- downloadResult{program: p, zipPath: zipPath, bytes: n, duration: time.Since(start), err: err}
 			}
 		}()
 	}
 
+	var queued []Program
+	for _, p := range toDownload {
+		if p.URL != "" && p.Count > 0 {
+			queued = append(queued, p)
+		}
+	}
+	if opts.ordered {
+		startOrderedReport(queued)
+	}
+
 	// Feed download jobs
 	go func() {
-		for _, p := range toDownload {
-			if p.URL != "" && p.Count > 0 {
-				downloadJobs <- p
-			}
+		for _, p := range queued {
+			downloadJobs <- p
 		}
 		close(downloadJobs)
 	}()
@@ -187,193 +931,697 @@ func parallelDownload(programs []Program, target string, workers int) {
 	// Stage 2: Parallel unzip (pipeline from downloads)
 	unzipJobs := make(chan unzipJob, workers*2)
 	var unzipWg sync.WaitGroup
+	mf := loadManifest()
 
-	// Start unzip workers
-	for i := 0; i < workers; i++ {
+	// Start unzip workers; -no-unzip stores archives from the collector
+	// instead.
+	unzipWorkers := workers
+	if opts.noUnzip {
+		unzipWorkers = 0
+	}
+	for i := 0; i < unzipWorkers; i++ {
 		unzipWg.Add(1)
 		go func() {
 			defer unzipWg.Done()
 			for job := range unzipJobs {
-				destDir := filepath.Join(chaosDir, job.program.Name)
-				os.MkdirAll(destDir, 0755)
+				if diskFull.Load() {
+					os.Remove(job.zipPath)
+					inflight.done(job.bytes)
+					programDone(job.program.Name)
+					continue
+				}
+				destDir := programDir(job.program.Name)
+				if needsProgramDir() {
+					os.MkdirAll(destDir, 0755)
+				}
+
+				var sum string
+				if opts.keepZip {
+					var err error
+					if sum, err = sha256File(job.zipPath); err != nil {
+						reportProgram(job.program.Name, errorf("[-] Hash %s: %v", job.program.Name, err))
+					} else if unchangedArchive(mf, job.program.Name, destDir, sum) {
+						reportProgram(job.program.Name, infof("[=] %s (unchanged)", job.program.Name))
+						validators.store(mf, job.program.Name)
+						addGlobal(job.program.Name)
+						summary.add(programSummary{Name: job.program.Name, Status: "unchanged", Bytes: job.bytes,
+							DownloadSeconds: job.duration.Seconds()})
+						os.Remove(job.zipPath)
+						inflight.done(job.bytes)
+						state.complete(job.program.Name)
+						programDone(job.program.Name)
+						continue
+					}
+				}
 
-				if err := unzip(job.zipPath, destDir); err != nil {
-					fmt.Fprintf(os.Stderr, "[-] Unzip %s: %v\n", job.program.Name, err)
+				lines, cut, err := unzip(job.zipPath, destDir)
+				if err != nil {
+					checkDiskFull(err)
+					emit(event{Type: "extract_failed", Program: job.program.Name, Error: err.Error()})
+					summary.failure(job.program.Name, "extract_failed", err)
+					reportProgram(job.program.Name, errorf("[-] Unzip %s: %v", job.program.Name, err))
+					if opts.keepFailedZip {
+						if err := kept.keep(job.program.Name, job.zipPath); err != nil {
+							checkDiskFull(err)
+							reportProgram(job.program.Name, errorf("[-] Keep failed archive %s: %v", job.program.Name, err))
+						}
+					}
 				} else {
-					fmt.Printf("[+] %s\n", job.program.Name)
+					emit(event{Type: "extract_finished", Program: job.program.Name, Subdomains: lines})
+					summary.add(programSummary{Name: job.program.Name, Status: "ok", Bytes: job.bytes,
+						Subdomains: lines, DownloadSeconds: job.duration.Seconds()})
+					status := []statusLine{infof("[+] %s", job.program.Name)}
+					if opts.verbose {
+						status[0] = infof("[+] %s (%s in %s, %.2f MB/s)", job.program.Name, formatSize(job.bytes),
+							job.duration.Round(time.Millisecond), float64(job.bytes)/1e6/max(job.duration.Seconds(), 1e-9))
+					}
+					status[0] = status[0].with(field{"url", job.program.URL}, field{"bytes", job.bytes},
+						field{"duration", job.duration.Seconds()}, field{"subdomains", lines})
+					if !opts.raw {
+						prev := mf.update(job.program.Name, lines)
+						mf.setSampled(job.program.Name, cut)
+						if warn, ok := shrinkWarning(job.program.Name, prev, lines); ok && !cut {
+							status = append(status, warn)
+						}
+					}
+					reportProgram(job.program.Name, status...)
+					addGlobal(job.program.Name)
+					indexed.add(job.program.Name)
+					state.complete(job.program.Name)
+				}
+				if opts.keepZip && err == nil {
+					if err := moveFile(job.zipPath, filepath.Join(destDir, "source.zip")); err != nil {
+						checkDiskFull(err)
+						reportProgram(job.program.Name, errorf("[-] Keep zip %s: %v", job.program.Name, err))
+					} else {
+						mf.setArchive(job.program.Name, sum)
+						validators.store(mf, job.program.Name)
+					}
 				}
 				os.Remove(job.zipPath)
+				inflight.done(job.bytes)
+				programDone(job.program.Name)
 			}
 		}()
 	}
 
 	// Collect download results and feed to unzip
+	tracker := loadFailureTracker()
 	var successCount, failCount int
+	var aborted bool
 	for result := range downloadResults {
+		if errors.Is(result.err, errNotModified) {
+			tracker.record(result.program, false)
+			successCount++
+			reportProgram(result.program.Name, infof("[=] %s (not modified)", result.program.Name))
+			addGlobal(result.program.Name)
+			summary.add(programSummary{Name: result.program.Name, Status: "unchanged", DownloadSeconds: result.duration.Seconds()})
+			state.complete(result.program.Name)
+			programDone(result.program.Name)
+			continue
+		}
 		if result.err != nil {
-			fmt.Fprintf(os.Stderr, "[-] Download %s: %v\n", result.program.Name, result.err)
+			if (aborted || diskFull.Load()) && errors.Is(result.err, context.Canceled) {
+				programDone(result.program.Name)
+				continue
+			}
+			checkDiskFull(result.err)
+			failed := errorf("[-] Download %s: %v", result.program.Name, result.err).
+				with(field{"url", result.program.URL}, field{"category", errorCategory(result.err)})
+			var de *DownloadError
+			if errors.As(result.err, &de) && de.StatusCode != 0 {
+				failed = failed.with(field{"status", de.StatusCode})
+			}
+			reportProgram(result.program.Name, failed)
+			programDone(result.program.Name)
+			summary.failure(result.program.Name, "download_failed", result.err)
+			if errorCategory(result.err) == "4xx" {
+				tracker.record(result.program, true)
+			}
 			failCount++
+			if opts.maxFailures > 0 && failCount >= opts.maxFailures && !aborted {
+				aborted = true
+				cancel()
+				fmt.Fprintf(errOut, "[!] %d downloads failed, aborting run (-max-failures)\n", failCount)
+			}
+			continue
+		}
+		tracker.record(result.program, false)
+		if diskFull.Load() {
+			os.Remove(result.zipPath)
+			inflight.done(result.bytes)
+			programDone(result.program.Name)
+			continue
+		}
+		if opts.noUnzip {
+			err := storeArchive(result)
+			inflight.done(result.bytes)
+			if err != nil {
+				checkDiskFull(err)
+				reportProgram(result.program.Name, errorf("[-] Save %s: %v", result.program.Name, err))
+				programDone(result.program.Name)
+				summary.failure(result.program.Name, "save_failed", err)
+				failCount++
+				continue
+			}
+			successCount++
+			state.complete(result.program.Name)
+			reportProgram(result.program.Name, infof("[+] %s (%s)", result.program.Name, formatSize(result.bytes)).
+				with(field{"url", result.program.URL}, field{"bytes", result.bytes}, field{"duration", result.duration.Seconds()}))
+			programDone(result.program.Name)
+			summary.add(programSummary{Name: result.program.Name, Status: "downloaded", Bytes: result.bytes,
+				DownloadSeconds: result.duration.Seconds()})
 			continue
 		}
 		successCount++
-		unzipJobs <- unzipJob{program: result.program, zipPath: result.zipPath}
+		unzipJobs <- unzipJob{program: result.program, zipPath: result.zipPath, bytes: result.bytes, duration: result.duration}
 	}
 	close(unzipJobs)
 	unzipWg.Wait()
+	stopProgress()
+	finishOrderedReport()
+	if global != nil {
+		if err := global.close(); err != nil {
+			fmt.Fprintf(errOut, "[-] -dedup-across-programs: %v\n", err)
+		} else {
+			fmt.Fprintf(statusOut, "[*] Wrote %s unique subdomains across the run to %s\n", formatCount(global.written), opts.dedupAcross)
+		}
+	}
+
+	if err := indexed.finish(); err != nil {
+		fmt.Fprintf(errOut, "[-] Update bloom filter: %v\n", err)
+	}
 
-	fmt.Printf("[*] Complete: %d success, %d failed\n", successCount, failCount)
+	if err := mf.save(); err != nil {
+		fmt.Fprintf(errOut, "[-] Save manifest: %v\n", err)
+	}
+	if err := tracker.save(); err != nil {
+		fmt.Fprintf(errOut, "[-] Save failures: %v\n", err)
+	}
+	if failCount == 0 && !aborted && !diskFull.Load() {
+		state.clear()
+	} else {
+		state.close()
+	}
+
+	emit(event{Type: "run_finished"})
+
+	summary.finish(successCount, failCount, aborted || diskFull.Load())
+	summary.printErrorReport(errOut)
+	kept.report()
+	if opts.verbose {
+		summary.printSlowest(statusOut)
+	}
+	if opts.summaryJSON != "" {
+		if err := summary.writeJSON(opts.summaryJSON); err != nil {
+			fmt.Fprintf(errOut, "[-] Write summary: %v\n", err)
+		}
+	}
+	if opts.report != "" {
+		if err := summary.writeHTMLReport(opts.report); err != nil {
+			fmt.Fprintf(errOut, "[-] Write report: %v\n", err)
+		}
+	}
+
+	text := fmt.Sprintf("[*] Complete: %d success, %d failed", successCount, failCount)
+	if skipped > 0 {
+		text += fmt.Sprintf(", %d skipped (exists, use -overwrite)", skipped)
+	}
+	if resumed > 0 {
+		text += fmt.Sprintf(", %d done in the interrupted run", resumed)
+	}
+	if aborted {
+		text = fmt.Sprintf("[*] Aborted early: %d success, %d failed (failure threshold reached)", successCount, failCount)
+	}
+	if diskFull.Load() {
+		text = fmt.Sprintf("[*] Stopped early: %d success, %d failed (disk full)", successCount, failCount)
+	}
+	total, totalBytes, extracted := summary.totals()
+	if opts.noUnzip {
+		text += fmt.Sprintf("\n[*] Total: %s downloaded across %s programs", formatSize(totalBytes), formatCount(successCount))
+	} else {
+		text += fmt.Sprintf("\n[*] Total: %s subdomains across %s programs", formatCount(total), formatCount(extracted))
+	}
+	stripped := strippedEntries.Load()
+	if stripped > 0 {
+		text += fmt.Sprintf("\n[*] Normalized %s entries (-strip-schemes/-strip-ports)", formatCount(int(stripped)))
+	}
+	out, _ := newFormatter(opts.format, os.Stdout)
+	out.Write(record{
+		Text: text,
+		Fields: []field{
+			{"success", successCount},
+			{"failed", failCount},
+			{"skipped", skipped},
+			{"aborted", aborted},
+			{"total_subdomains", total},
+			{"total_bytes", totalBytes},
+			{"programs", extracted},
+			{"normalized", stripped},
+			{"disk_full", diskFull.Load()},
+		},
+	})
+	out.Close()
+
+	if diskFull.Load() {
+		fmt.Fprintf(errOut, "[-] Disk full: free space under %s and rerun with -resume-run\n", chaosDir)
+		os.Exit(1)
+	}
+	return summary.failures()
+}
+
+// skipExisting drops the programs that already have local data, noting
+// each one, so a re-run does not clobber local edits without -overwrite.
+func skipExisting(programs []Program, summary *runSummary) []Program {
+	var kept []Program
+	for _, p := range programs {
+		existing := subdomainsPath(p.Name)
+		switch {
+		case opts.noUnzip:
+			existing = filepath.Join(programDir(p.Name), "source.zip")
+		case opts.raw:
+			existing = programDir(p.Name)
+		}
+		if !fileExists(existing) {
+			kept = append(kept, p)
+			continue
+		}
+		reportProgram(p.Name, infof("[=] %s (exists)", p.Name))
+		summary.add(programSummary{Name: p.Name, Status: "exists"})
+	}
+	return kept
+}
+
+// storeArchive moves a downloaded archive to chaos/<name>/source.zip
+// for -no-unzip.
+func storeArchive(result downloadResult) error {
+	destDir := programDir(result.program.Name)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		os.Remove(result.zipPath)
+		return err
+	}
+	if err := moveFile(result.zipPath, filepath.Join(destDir, "source.zip")); err != nil {
+		os.Remove(result.zipPath)
+		return err
+	}
+	return nil
+}
+
+// shrinkWarning reports a program whose subdomain count dropped by more
+// than -shrink-threshold percent since the previous sync.
+func shrinkWarning(program string, prev, cur int) (statusLine, bool) {
+	if prev == 0 || cur >= prev {
+		return statusLine{}, false
+	}
+	pct := float64(prev-cur) / float64(prev) * 100
+	if pct <= opts.shrinkPercent {
+		return statusLine{}, false
+	}
+	return warnf("[!] %s shrank from %d to %d subdomains (-%d, -%.1f%%)",
+		program, prev, cur, prev-cur, pct), true
 }
 
-func downloadZip(p Program) (string, error) {
-	resp, err := http.Get(p.URL)
+// orderPrograms sorts programs in place by the given -order mode.
+func orderPrograms(programs []Program, order string) error {
+	switch order {
+	case "index":
+	case "largest":
+		sort.SliceStable(programs, func(i, j int) bool {
+			return programs[i].Count > programs[j].Count
+		})
+	case "smallest":
+		sort.SliceStable(programs, func(i, j int) bool {
+			return programs[i].Count < programs[j].Count
+		})
+	case "random":
+		rand.Shuffle(len(programs), func(i, j int) {
+			programs[i], programs[j] = programs[j], programs[i]
+		})
+	default:
+		return fmt.Errorf("unknown order %q", order)
+	}
+	return nil
+}
+
+// checkScheme rejects program URLs that are not HTTPS unless
+// -allow-insecure-http is set, in which case it warns.
+func checkScheme(p Program) error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return &DownloadError{Program: p.Name, Err: err}
+	}
+	if strings.EqualFold(u.Scheme, "https") {
+		return nil
+	}
+	if !opts.allowInsecure {
+		return &DownloadError{Program: p.Name, Err: fmt.Errorf("refusing %s URL %s: %w (use -allow-insecure-http)", u.Scheme, p.URL, errInsecureURL)}
+	}
+	reportProgram(p.Name, warnf("[!] %s: downloading over %s, not HTTPS", p.Name, u.Scheme))
+	return nil
+}
+
+// downloadZip fetches p's archive to a temporary file and returns its
+// path and size.
+func downloadZip(ctx context.Context, p Program) (string, int64, error) {
+	if err := checkScheme(p); err != nil {
+		emit(event{Type: "download_failed", Program: p.Name, Error: err.Error()})
+		return "", 0, err
+	}
+	start := time.Now()
+	emit(event{Type: "download_started", Program: p.Name})
+	path, n, err := fetchZipSigned(ctx, p)
+	if errors.Is(err, errNotModified) {
+		emit(event{Type: "download_not_modified", Program: p.Name})
+		return "", 0, err
+	}
 	if err != nil {
-		return "", err
+		emit(event{Type: "download_failed", Program: p.Name, Error: err.Error()})
+		return "", 0, err
+	}
+	emit(event{Type: "download_finished", Program: p.Name, Bytes: n, Duration: time.Since(start).Seconds()})
+	return path, n, nil
+}
+
+func fetchZip(ctx context.Context, p Program) (string, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.URL, nil)
+	if err != nil {
+		return "", 0, &DownloadError{Program: p.Name, Err: err}
+	}
+	if av, ok := validators.conditional(p.Name); ok {
+		if av.ETag != "" {
+			req.Header.Set("If-None-Match", av.ETag)
+		}
+		if av.LastModified != "" {
+			req.Header.Set("If-Modified-Since", av.LastModified)
+		}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", 0, &DownloadError{Program: p.Name, Err: err}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return "", 0, &DownloadError{Program: p.Name, StatusCode: resp.StatusCode, Err: errNotModified}
+	}
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("status %d", resp.StatusCode)
+		de := &DownloadError{Program: p.Name, StatusCode: resp.StatusCode}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			de.RetryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return "", 0, de
 	}
 
-	tmpFile, err := os.CreateTemp("", "chaos-*.zip")
+	progress.begin(p.Name, resp.ContentLength)
+	defer progress.end(p.Name)
+
+	tmpFile, err := os.CreateTemp(opts.tmpDir, "chaos-*.zip")
 	if err != nil {
-		return "", err
+		return "", 0, &DownloadError{Program: p.Name, StatusCode: resp.StatusCode, Err: err}
 	}
 	tmpPath := tmpFile.Name()
 
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		tmpFile.Close()
+	var body io.Reader = resp.Body
+	if eventsEnc != nil || progress != nil {
+		body = &progressReader{r: resp.Body, program: p.Name, total: resp.ContentLength, last: time.Now()}
+	}
+	n, err := io.Copy(tmpFile, body)
+	if cerr := tmpFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
 		os.Remove(tmpPath)
-		return "", err
+		return "", 0, &DownloadError{Program: p.Name, StatusCode: resp.StatusCode, Err: err}
 	}
-	tmpFile.Close()
+	validators.served(p.Name, archiveValidator{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")})
 
-	return tmpPath, nil
+	return tmpPath, n, nil
 }
 
-func unzip(src, dest string) error {
-	r, err := zip.OpenReader(src)
+// unzip merges every .txt entry of the archive at src into
+// dest/subdomains.txt and returns the number of lines written and
+// whether -sample cut the list short. The
+// program name reported in errors is the base name of dest. Zip is the
+// expected format; gzip-compressed tarballs are recognized by their magic
+// bytes and extracted the same way.
+//
+// With -verify-extract the merged list is checked against the archive
+// afterwards and extracted once more on a mismatch.
+func unzip(src, dest string) (int, bool, error) {
+	lines, cut, err := extractArchive(src, dest)
+	if err != nil || !opts.verifyExtract || opts.raw || cut {
+		return lines, cut, err
+	}
+	program := filepath.Base(dest)
+	if err = verifyExtract(src, program); errors.Is(err, errExtractMismatch) {
+		reportProgram(program, warnf("[!] %s: %v, extracting again", program, err))
+		os.Remove(outputPath(program)) // so -keep-previous keeps the real previous list
+		if lines, cut, err = extractArchive(src, dest); err != nil {
+			return 0, false, err
+		}
+		err = verifyExtract(src, program)
+	}
 	if err != nil {
-		return err
+		return 0, false, &UnzipError{Program: program, Err: err}
 	}
-	defer r.Close()
+	return lines, cut, nil
+}
 
-	// Create single output file for all subdomains
-	outPath := filepath.Join(dest, "subdomains.txt")
-	outFile, err := os.Create(outPath)
+// extractArchive is unzip without the -verify-extract check.
+func extractArchive(src, dest string) (int, bool, error) {
+	f, err := os.Open(src)
 	if err != nil {
-		return err
+		return 0, false, &UnzipError{Program: filepath.Base(dest), Err: err}
 	}
-	defer outFile.Close()
-
-	writer := bufio.NewWriter(outFile)
-	defer writer.Flush()
+	defer f.Close()
 
-	for _, f := range r.File {
-		if f.FileInfo().IsDir() || !strings.HasSuffix(f.Name, ".txt") {
-			continue
-		}
+	limit := newSizeLimit(opts.maxUncompressed)
+	magic := make([]byte, 2)
+	if _, err := f.ReadAt(magic, 0); err == nil && bytes.Equal(magic, gzipMagic) {
+		return extractTarGz(f, dest, limit)
+	}
 
-		rc, err := f.Open()
-		if err != nil {
-			return err
-		}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, &UnzipError{Program: filepath.Base(dest), Err: err}
+	}
+	return unzipFrom(f, info.Size(), dest, limit)
+}
 
-		_, err = io.Copy(writer, rc)
-		rc.Close()
-		if err != nil {
-			return err
-		}
+// discardPartial removes the output of an extraction that hit
+// -max-uncompressed or a full disk, so no truncated list is left behind.
+func discardPartial(f *os.File, err error) {
+	if errors.Is(err, errTooLarge) || isDiskFull(err) {
+		f.Close()
+		os.Remove(f.Name())
 	}
-	return nil
 }
 
-func parallelQuery(domain string, workers int) {
-	domain = strings.ToLower(domain)
+// unzipFrom is unzip for an archive held in any io.ReaderAt, such as an
+// in-memory buffer.
+func unzipFrom(ra io.ReaderAt, size int64, dest string, limit *sizeLimit) (int, bool, error) {
+	program := filepath.Base(dest)
 
-	// Collect all txt files
-	var files []string
-	filepath.Walk(chaosDir, func(path string, info os.FileInfo, err error) error {
-		if err == nil && !info.IsDir() && strings.HasSuffix(path, "subdomains.txt") {
-			files = append(files, path)
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return 0, false, &UnzipError{Program: program, Err: err}
+	}
+
+	if opts.raw {
+		return 0, false, extractRaw(r, dest, limit)
+	}
+
+	var entries []*zip.File
+	var declared uint64
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() && strings.HasSuffix(f.Name, ".txt") {
+			entries = append(entries, f)
+			declared += f.UncompressedSize64
 		}
-		return nil
-	})
+	}
+	if err := limit.declared(declared); err != nil {
+		return 0, false, &UnzipError{Program: program, Err: err}
+	}
 
-	if len(files) == 0 {
-		return
+	// Create single output file for all subdomains
+	outFile, err := createSubdomainsFile(dest)
+	if err != nil {
+		return 0, false, &UnzipError{Program: program, Err: err}
 	}
+	defer outFile.Close()
 
-	// File jobs channel
-	fileJobs := make(chan string, len(files))
-	results := make(chan queryResult, workers)
+	writer, flush := extractWriter(outFile)
+	defer flush()
+	counter := &lineCounter{w: writer, max: opts.sample}
 
-	// Start query workers
-	var wg sync.WaitGroup
-	for i := 0; i < workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for path := range fileJobs {
-				count := countMatches(path, domain)
-				if count > 0 {
-					results <- queryResult{file: path, matchCount: count}
-				}
-			}
-		}()
+	if err := extractEntries(entries, counter, dest, limit); err != nil && !errors.Is(err, errSampleDone) {
+		flush()
+		discardPartial(outFile, err)
+		return 0, false, err
+	}
+	if err := closeList(outFile, flush); err != nil {
+		return 0, false, &UnzipError{Program: program, Err: err}
 	}
+	return counter.lines, counter.cut, nil
+}
 
-	// Feed file jobs
-	go func() {
-		for _, f := range files {
-			fileJobs <- f
+// closeList flushes the extraction writer of the list f and closes it.
+// With -workers-io a failed write only surfaces here, so the error must
+// not be dropped: the list is discarded like after a failed entry.
+func closeList(f *os.File, flush func() error) error {
+	err := flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		discardPartial(f, err)
+	}
+	return err
+}
+
+// createSubdomainsFile creates the list of the program extracted to dest
+// (dest/subdomains.txt, or chaos/<name>.txt with -flat), first moving an
+// existing one aside when -keep-previous is set.
+func createSubdomainsFile(dest string) (*os.File, error) {
+	outPath := outputPath(filepath.Base(dest))
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return nil, err
+	}
+	if opts.keepPrevious && fileExists(outPath) {
+		if err := os.Rename(outPath, previousPath(outPath)); err != nil {
+			return nil, err
 		}
-		close(fileJobs)
-	}()
+	}
+	return os.Create(outPath)
+}
 
-	// Close results when workers done
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+// errSampleDone stops an extraction once -sample lines are written and
+// more follow.
+var errSampleDone = errors.New("sample complete")
+
+// lineCounter passes writes through to w, counting newlines. With max
+// set it writes up to the max-th line and fails with errSampleDone when
+// anything follows, setting cut. A list of exactly max lines is not cut.
+type lineCounter struct {
+	w     io.Writer
+	lines int
+	max   int
+	cut   bool
+}
 
-	// Find best match
-	var best queryResult
-	for result := range results {
-		if result.matchCount > best.matchCount {
-			best = result
+func (c *lineCounter) Write(p []byte) (int, error) {
+	full := false
+	if c.max > 0 {
+		if c.lines >= c.max {
+			if len(p) == 0 {
+				return 0, nil
+			}
+			c.cut = true
+			return 0, errSampleDone
+		}
+		if cut := nthIndex(p, '\n', c.max-c.lines); cut >= 0 && cut+1 < len(p) {
+			p, full = p[:cut+1], true
 		}
 	}
+	n, err := c.w.Write(p)
+	c.lines += bytes.Count(p[:n], []byte{'\n'})
+	if err == nil && full {
+		c.cut = true
+		err = errSampleDone
+	}
+	return n, err
+}
 
-	if best.file == "" {
-		return
+// nthIndex returns the index of the n-th occurrence of b in p, or -1.
+func nthIndex(p []byte, b byte, n int) int {
+	off := 0
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(p[off:], b)
+		if i < 0 {
+			return -1
+		}
+		off += i + 1
 	}
+	return off - 1
+}
 
-	// Output the subdomains.txt contents
-	f, err := os.Open(best.file)
-	if err != nil {
-		return
+// strippedEntries counts the lines rewritten by -strip-schemes and
+// -strip-ports across all unzip workers.
+var strippedEntries atomic.Int64
+
+// copyEntry appends the lines of an archive entry to counter, cleaning
+// them first when -strip-schemes or -strip-ports is set.
+func copyEntry(counter *lineCounter, r io.Reader) error {
+	if !opts.stripSchemes && !opts.stripPorts {
+		_, err := io.Copy(counter, r)
+		return err
 	}
-	defer f.Close()
-	io.Copy(os.Stdout, f)
+	var buf bytes.Buffer
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if clean := stripHostname(line, opts.stripSchemes, opts.stripPorts); clean != line {
+			strippedEntries.Add(1)
+			line = clean
+		}
+		buf.Reset()
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+		if _, err := counter.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
 }
 
-func countMatches(path, domain string) int {
-	f, err := os.Open(path)
-	if err != nil {
-		return 0
+// extractRaw writes every entry of r under dest with its original path,
+// mirroring the archive exactly. Entries that would escape dest are
+// rejected.
+func extractRaw(r *zip.Reader, dest string, limit *sizeLimit) error {
+	program := filepath.Base(dest)
+
+	var declared uint64
+	for _, f := range r.File {
+		declared += f.UncompressedSize64
+	}
+	if err := limit.declared(declared); err != nil {
+		return &UnzipError{Program: program, Err: err}
 	}
-	defer f.Close()
 
-	count := 0
-	scanner := bufio.NewScanner(f)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+	for _, f := range r.File {
+		path := filepath.Join(dest, f.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return &UnzipError{Program: program, Entry: f.Name, Err: fmt.Errorf("illegal path")}
+		}
 
-	for scanner.Scan() {
-		if strings.Contains(strings.ToLower(scanner.Text()), domain) {
-			count++
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return &UnzipError{Program: program, Entry: f.Name, Err: err}
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return &UnzipError{Program: program, Entry: f.Name, Err: err}
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return &UnzipError{Program: program, Entry: f.Name, Err: err}
+		}
+		out, err := os.Create(path)
+		if err != nil {
+			rc.Close()
+			return &UnzipError{Program: program, Entry: f.Name, Err: err}
+		}
+		_, err = io.Copy(out, limit.reader(rc))
+		rc.Close()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+			return &UnzipError{Program: program, Entry: f.Name, Err: err}
 		}
 	}
-	return count
+	return nil
 }
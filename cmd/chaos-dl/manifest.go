@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestEntry records what the last extraction of a program produced.
+type manifestEntry struct {
+	Subdomains int       `json:"subdomains"`
+	Previous   int       `json:"previous,omitempty"`
+	Updated    time.Time `json:"updated"`
+	Accessed   time.Time `json:"accessed,omitempty"`
+	SHA256     string    `json:"sha256,omitempty"`
+	// Sampled marks a list cut short by -sample.
+	Sampled bool `json:"sampled,omitempty"`
+	// ETag and LastModified are the validators the kept archive was
+	// served with, for -mirror's conditional requests.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// manifest is the per-program state stored alongside the data in
+// chaos/manifest.json. It is safe for use by concurrent unzip workers.
+type manifest struct {
+	mu       sync.Mutex
+	path     string
+	Programs map[string]*manifestEntry `json:"programs"`
+}
+
+func loadManifest() *manifest {
+	m := &manifest{
+		path:     filepath.Join(chaosDir, "manifest.json"),
+		Programs: make(map[string]*manifestEntry),
+	}
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return m
+	}
+	json.Unmarshal(data, m)
+	if m.Programs == nil {
+		m.Programs = make(map[string]*manifestEntry)
+	}
+	return m
+}
+
+// update stores a fresh subdomain count for program and returns the
+// count from the previous sync, or 0 if there was none.
+func (m *manifest) update(program string, count int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.Programs[program]
+	if !ok {
+		e = &manifestEntry{}
+		m.Programs[program] = e
+	}
+	prev := e.Subdomains
+	e.Previous = prev
+	e.Subdomains = count
+	e.Updated = time.Now().UTC()
+	return prev
+}
+
+func (m *manifest) get(program string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.Programs[program]
+	if !ok {
+		return manifestEntry{}, false
+	}
+	return *e, true
+}
+
+// setSampled records whether the list of program was cut short by
+// -sample.
+func (m *manifest) setSampled(program string, sampled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.Programs[program]; ok {
+		e.Sampled = sampled
+	}
+}
+
+// setArchive records the SHA-256 of the archive kept by -keep-zip.
+func (m *manifest) setArchive(program, sum string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.Programs[program]; ok {
+		e.SHA256 = sum
+	}
+}
+
+// setValidators records the validators the kept archive was served with.
+func (m *manifest) setValidators(program string, av archiveValidator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.Programs[program]; ok {
+		e.ETag, e.LastModified = av.ETag, av.LastModified
+	}
+}
+
+// touch records that programs were just returned by a query.
+func (m *manifest) touch(programs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, p := range programs {
+		if e, ok := m.Programs[p]; ok {
+			e.Accessed = now
+		}
+	}
+}
+
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
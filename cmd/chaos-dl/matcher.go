@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Matcher decides whether a stored line matches a query.
+type Matcher interface {
+	Match(line string) bool
+}
+
+// substringMatcher matches lines containing term, ignoring case. term
+// must be lowercase.
+type substringMatcher struct {
+	term string
+}
+
+func (m substringMatcher) Match(line string) bool {
+	return strings.Contains(strings.ToLower(line), m.term)
+}
+
+// wildcardMatcher applies the -wildcards policy to stored "*.domain"
+// lines and defers every other line to inner.
+//
+//	match   a stored wildcard matches a query for any name under it
+//	ignore  wildcard lines never match
+//	expand  reserved; wildcard lines are treated like any other line
+type wildcardMatcher struct {
+	inner  Matcher
+	query  string
+	policy string
+}
+
+func (m wildcardMatcher) Match(line string) bool {
+	if !strings.HasPrefix(line, "*.") {
+		return m.inner.Match(line)
+	}
+	switch m.policy {
+	case "ignore":
+		return false
+	case "match":
+		base := strings.ToLower(strings.TrimSpace(line[2:]))
+		if strings.HasSuffix(m.query, "."+base) {
+			return true
+		}
+	}
+	return m.inner.Match(line)
+}
+
+func validWildcardPolicy(policy string) error {
+	switch policy {
+	case "match", "ignore", "expand":
+		return nil
+	}
+	return fmt.Errorf("unknown wildcard policy %q", policy)
+}
+
+// labelMatcher matches lines whose hostname has between min and max
+// dot-separated labels, for -min-labels and -max-labels. A bound of 0 is
+// not checked.
+type labelMatcher struct {
+	min, max int
+}
+
+func (m labelMatcher) Match(line string) bool {
+	host, _ := splitRecord(line)
+	n := strings.Count(strings.TrimSuffix(strings.TrimSpace(host), "."), ".") + 1
+	return n >= m.min && (m.max == 0 || n <= m.max)
+}
+
+// allMatcher matches lines that every one of its matchers matches.
+type allMatcher []Matcher
+
+func (m allMatcher) Match(line string) bool {
+	for _, inner := range m {
+		if !inner.Match(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// anyMatcher matches lines that at least one of its matchers matches.
+type anyMatcher []Matcher
+
+func (m anyMatcher) Match(line string) bool {
+	for _, inner := range m {
+		if inner.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// newQueryMatcher builds the matcher for the -q terms from the query
+// flags, combining several terms as -logic says.
+func newQueryMatcher(terms []string) Matcher {
+	matchers := make([]Matcher, len(terms))
+	for i, term := range terms {
+		term = strings.ToLower(term)
+		matchers[i] = wildcardMatcher{inner: substringMatcher{term: term}, query: term, policy: opts.wildcards}
+	}
+	var m Matcher
+	switch {
+	case len(matchers) == 1:
+		m = matchers[0]
+	case opts.logic == "or":
+		m = anyMatcher(matchers)
+	default:
+		m = allMatcher(matchers)
+	}
+	if opts.minLabels > 0 || opts.maxLabels > 0 {
+		m = allMatcher{m, labelMatcher{min: opts.minLabels, max: opts.maxLabels}}
+	}
+	return m
+}
+
+// queryTitle describes the -q terms for reports.
+func queryTitle(terms []string) string {
+	return strings.Join(terms, " "+strings.ToUpper(opts.logic)+" ")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// membersQuery reads hostnames (a file, or "-" for stdin) and reports for
+// each whether it is stored, as a whole line, in any program and in
+// which ones. Every list is scanned once against the whole input set.
+func membersQuery(src string, workers int) error {
+	var in io.Reader = os.Stdin
+	if src != "-" {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var order []string
+	set := make(map[string]bool)
+	scanner := newLineScanner(in)
+	for scanner.Scan() {
+		host := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if host != "" && !set[host] {
+			set[host] = true
+			order = append(order, host)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(order) == 0 {
+		return fmt.Errorf("no hostnames in %s", src)
+	}
+
+	failures := &scanFailures{}
+	defer failures.report()
+
+	files := make(chan string, workers*2)
+	go walkSubdomainFiles(chaosDir, workers, files)
+
+	found := make(map[string][]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range files {
+				hits, err := memberHits(path, set)
+				if err != nil {
+					failures.record(path, err)
+				}
+				if len(hits) == 0 {
+					continue
+				}
+				program := programOf(path)
+				mu.Lock()
+				for _, host := range hits {
+					found[host] = append(found[host], program)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	out, _ := newFormatter(opts.format, os.Stdout)
+	hits := 0
+	for _, host := range order {
+		programs := found[host]
+		sort.Strings(programs)
+		text := fmt.Sprintf("[-] %s not found", host)
+		if len(programs) > 0 {
+			hits++
+			text = fmt.Sprintf("[+] %s found in %s", host, strings.Join(programs, ", "))
+		}
+		out.Write(record{
+			Text: text,
+			Fields: []field{
+				{"subdomain", host},
+				{"found", len(programs) > 0},
+				{"programs", programs},
+			},
+		})
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(statusOut, "[*] %d of %d hostnames found\n", hits, len(order))
+	return nil
+}
+
+// memberHits returns the hostnames of set stored in path. set is only
+// read, so concurrent calls may share it.
+func memberHits(path string, set map[string]bool) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hits []string
+	seen := make(map[string]bool)
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		host, _ := splitRecord(scanner.Text())
+		host = strings.ToLower(host)
+		if set[host] && !seen[host] {
+			seen[host] = true
+			hits = append(hits, host)
+		}
+	}
+	return hits, scanner.Err()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// mergePrograms writes the deduplicated, sorted union of the named
+// programs' subdomains into a new program dest. The sources are left
+// untouched.
+func mergePrograms(dest string, sources []string) error {
+	if len(sources) < 2 {
+		return fmt.Errorf("need at least two programs to merge")
+	}
+	if err := validProgramName(dest); err != nil {
+		return err
+	}
+
+	union := make(map[string]bool)
+	for _, src := range sources {
+		set, err := readSet(subdomainsPath(src))
+		if err != nil {
+			return fmt.Errorf("%s: %w", src, err)
+		}
+		for s := range set {
+			union[s] = true
+		}
+	}
+
+	lines := make([]string, 0, len(union))
+	for s := range union {
+		lines = append(lines, s)
+	}
+	sort.Strings(lines)
+
+	path := outputPath(dest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeLines(path, lines); err != nil {
+		return err
+	}
+
+	mf := loadManifest()
+	mf.update(dest, len(lines))
+	if err := mf.save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(statusOut, "[+] Merged %d programs into %s (%d subdomains)\n", len(sources), dest, len(lines))
+	return nil
+}
+
+// writeLines replaces path with lines, one per line, via a temporary
+// file so readers never see a partial result.
+func writeLines(path string, lines []string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, l := range lines {
+		w.WriteString(l)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
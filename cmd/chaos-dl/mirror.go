@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checksumsFile is the per-program sha256sum-style list -mirror keeps
+// next to source.zip and subdomains.txt.
+const checksumsFile = "SHA256SUMS"
+
+// archiveValidator holds the ETag and Last-Modified a program's archive
+// was served with.
+type archiveValidator struct {
+	ETag         string
+	LastModified string
+}
+
+// archiveValidators makes -mirror downloads conditional: a program whose
+// kept archive and list are in place is requested with the validators
+// recorded in the manifest, and the validators of each archive served in
+// full are collected for the manifest. It is nil outside -mirror.
+var validators *archiveValidators
+
+type archiveValidators struct {
+	mu    sync.Mutex
+	known map[string]archiveValidator
+	fresh map[string]archiveValidator
+}
+
+func newArchiveValidators(mf *manifest) *archiveValidators {
+	v := &archiveValidators{known: make(map[string]archiveValidator), fresh: make(map[string]archiveValidator)}
+	for name, e := range mf.Programs {
+		if e.ETag == "" && e.LastModified == "" {
+			continue
+		}
+		if !fileExists(filepath.Join(programDir(name), "source.zip")) || !fileExists(subdomainsPath(name)) {
+			continue
+		}
+		v.known[name] = archiveValidator{ETag: e.ETag, LastModified: e.LastModified}
+	}
+	return v
+}
+
+// conditional returns the validators to send for program.
+func (v *archiveValidators) conditional(program string) (archiveValidator, bool) {
+	if v == nil {
+		return archiveValidator{}, false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	av, ok := v.known[program]
+	return av, ok
+}
+
+// served records the validators of an archive served in full.
+func (v *archiveValidators) served(program string, av archiveValidator) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fresh[program] = av
+}
+
+// store copies the validators program's archive was just served with
+// into the manifest, once the archive is kept.
+func (v *archiveValidators) store(mf *manifest, program string) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	av, ok := v.fresh[program]
+	v.mu.Unlock()
+	if ok {
+		mf.setValidators(program, av)
+	}
+}
+
+// mirrorAll keeps a complete copy of the dataset for serving or rsync:
+// every program's archive as source.zip, its extracted list, a
+// SHA256SUMS file per program, and chaos/manifest.json with counts,
+// archive checksums and validators. Later runs ask the server for each
+// archive conditionally and skip extraction when the checksum is
+// unchanged, so only changed programs are transferred and rewritten.
+// Programs that left the index are not deleted.
+func mirrorAll(programs []Program, workers int) error {
+	if opts.flat || opts.raw || opts.noUnzip || opts.sample > 0 {
+		return fmt.Errorf("-mirror keeps the standard layout; drop -flat, -raw, -no-unzip and -sample")
+	}
+	opts.keepZip = true
+	opts.overwrite = true
+	validators = newArchiveValidators(loadManifest())
+
+	selected := selectPrograms(programs, "all")
+	parallelDownload(selected, workers)
+
+	written := 0
+	for _, p := range selected {
+		ok, err := writeChecksums(p.Name)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] Checksums %s: %v\n", p.Name, err)
+			continue
+		}
+		if ok {
+			written++
+		}
+	}
+	fmt.Fprintf(statusOut, "[*] Mirror of %d programs in %s (%d checksum files updated)\n", len(selected), chaosDir, written)
+	return nil
+}
+
+// writeChecksums rewrites program's SHA256SUMS when it is missing or
+// older than the files it covers, and reports whether it did. Programs
+// without a kept archive are left alone.
+func writeChecksums(program string) (bool, error) {
+	dir := programDir(program)
+	files := []string{"source.zip", "subdomains.txt"}
+	sumsPath := filepath.Join(dir, checksumsFile)
+	sumsInfo, sumsErr := os.Stat(sumsPath)
+
+	stale := sumsErr != nil
+	for _, name := range files {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return false, nil
+		}
+		if sumsErr == nil && info.ModTime().After(sumsInfo.ModTime()) {
+			stale = true
+		}
+	}
+	if !stale {
+		return false, nil
+	}
+
+	var data []byte
+	for _, name := range files {
+		sum, err := sha256File(filepath.Join(dir, name))
+		if err != nil {
+			return false, err
+		}
+		data = fmt.Appendf(data, "%s  %s\n", sum, name)
+	}
+	tmp := sumsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, sumsPath)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pickVisible is the number of candidates listed per filter.
+const pickVisible = 20
+
+// pickPrograms lets the user choose programs by typing fuzzy filters and
+// toggling results by number. When stdin is not a terminal it falls back
+// to reading one program name per line.
+func pickPrograms(programs []Program) ([]Program, error) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return pickFromReader(programs, os.Stdin)
+	}
+
+	in := bufio.NewScanner(os.Stdin)
+	selected := make(map[string]bool)
+	var shown []Program
+
+	fmt.Fprintln(os.Stderr, "Type to filter, numbers (e.g. 1 3 5-7) to toggle, an empty line to download the selection.")
+	for {
+		fmt.Fprintf(os.Stderr, "[%d selected] > ", len(selected))
+		if !in.Scan() {
+			return nil, in.Err()
+		}
+		line := strings.TrimSpace(in.Text())
+
+		if line == "" {
+			break
+		}
+		if nums, ok := parseNumbers(line); ok {
+			for _, n := range nums {
+				if n < 1 || n > len(shown) {
+					continue
+				}
+				name := shown[n-1].Name
+				if selected[name] {
+					delete(selected, name)
+				} else {
+					selected[name] = true
+				}
+			}
+		} else {
+			shown = fuzzyFilter(programs, line)
+		}
+
+		for i, p := range shown {
+			mark := " "
+			if selected[p.Name] {
+				mark = "*"
+			}
+			fmt.Fprintf(os.Stderr, "%s %2d  %-40s %d\n", mark, i+1, p.Name, p.Count)
+		}
+	}
+
+	var picked []Program
+	for _, p := range programs {
+		if selected[p.Name] {
+			picked = append(picked, p)
+		}
+	}
+	return picked, nil
+}
+
+func pickFromReader(programs []Program, r io.Reader) ([]Program, error) {
+	byName := make(map[string]Program, len(programs))
+	for _, p := range programs {
+		byName[strings.ToLower(p.Name)] = p
+	}
+
+	var picked []Program
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" {
+			continue
+		}
+		if p, ok := byName[strings.ToLower(name)]; ok {
+			picked = append(picked, p)
+		} else {
+			fmt.Fprintf(errOut, "[-] Program '%s' not found\n", name)
+		}
+	}
+	return picked, scanner.Err()
+}
+
+// parseNumbers parses a list like "1 3 5-7". ok is false if s contains
+// anything else, in which case it is treated as a filter.
+func parseNumbers(s string) ([]int, bool) {
+	var nums []int
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		lo, hi, isRange := strings.Cut(f, "-")
+		a, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, false
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(hi); err != nil {
+				return nil, false
+			}
+		}
+		for n := a; n <= b; n++ {
+			nums = append(nums, n)
+		}
+	}
+	return nums, len(nums) > 0
+}
+
+// fuzzyFilter returns the best pickVisible programs whose names contain
+// the characters of query in order, preferring tight, early matches.
+func fuzzyFilter(programs []Program, query string) []Program {
+	type scored struct {
+		p     Program
+		score int
+	}
+	var hits []scored
+	for _, p := range programs {
+		if score, ok := fuzzyScore(strings.ToLower(p.Name), strings.ToLower(query)); ok {
+			hits = append(hits, scored{p, score})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score < hits[j].score
+		}
+		return hits[i].p.Count > hits[j].p.Count
+	})
+
+	var out []Program
+	for i := 0; i < len(hits) && i < pickVisible; i++ {
+		out = append(out, hits[i].p)
+	}
+	return out
+}
+
+// fuzzyScore matches query as a subsequence of name. Lower scores are
+// better: the score is the index at which the match completes, so tight
+// matches near the start of the name win.
+func fuzzyScore(name, query string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	qi := 0
+	for i := 0; i < len(name); i++ {
+		if name[i] == query[qi] {
+			qi++
+			if qi == len(query) {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressRedraw is how often the -progress-bar display is redrawn
+	// on a terminal.
+	progressRedraw = 150 * time.Millisecond
+
+	// progressPlainEvery is how often each active download is reported
+	// as a plain line when the output is not a terminal.
+	progressPlainEvery = 10 * time.Second
+
+	progressBarWidth = 30
+)
+
+// progress is the -progress-bar display of the running download run, or
+// nil. Its methods do nothing on nil.
+var progress *progressDisplay
+
+// transfer is one download shown by the progress display.
+type transfer struct {
+	name      string
+	read      int64
+	total     int64
+	lastPlain time.Time
+}
+
+// progressDisplay shows one bar per active download, redrawn in place
+// below the scrolling status lines like docker pull. When the output is
+// not a terminal it prints a plain status line per download instead.
+type progressDisplay struct {
+	mu     sync.Mutex
+	out    io.Writer
+	tty    bool
+	rows   int
+	active []*transfer
+	drawn  int
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// startProgress begins drawing to out until stopProgress. Status and
+// error output is rerouted through the display so it never overwrites
+// the bars.
+func startProgress(out io.Writer) {
+	d := &progressDisplay{out: out, stop: make(chan struct{}), done: make(chan struct{})}
+	if f, ok := out.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb" {
+			d.tty = true
+			d.rows = terminalRows(f)
+		}
+	}
+	if d.rows <= 0 {
+		d.rows, _ = strconv.Atoi(os.Getenv("LINES"))
+	}
+	if d.rows <= 0 {
+		d.rows = 24
+	}
+	if d.tty {
+		statusOut = &progressWriter{d: d, w: statusOut}
+		errOut = &progressWriter{d: d, w: errOut}
+	}
+	progress = d
+	go d.run()
+}
+
+// stopProgress clears the bars and restores status output.
+func stopProgress() {
+	d := progress
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	if w, ok := statusOut.(*progressWriter); ok {
+		statusOut = w.w
+	}
+	if w, ok := errOut.(*progressWriter); ok {
+		errOut = w.w
+	}
+	progress = nil
+}
+
+func (d *progressDisplay) run() {
+	defer close(d.done)
+	interval := progressPlainEvery
+	if d.tty {
+		interval = progressRedraw
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.mu.Lock()
+			if d.tty {
+				d.erase()
+				d.draw()
+			} else {
+				d.plain()
+			}
+			d.mu.Unlock()
+		case <-d.stop:
+			d.mu.Lock()
+			d.erase()
+			d.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (d *progressDisplay) begin(name string, total int64) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active = append(d.active, &transfer{name: name, total: total, lastPlain: time.Now()})
+}
+
+func (d *progressDisplay) update(name string, read int64) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, t := range d.active {
+		if t.name == name {
+			t.read = read
+			return
+		}
+	}
+}
+
+func (d *progressDisplay) end(name string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, t := range d.active {
+		if t.name == name {
+			d.active = append(d.active[:i], d.active[i+1:]...)
+			return
+		}
+	}
+}
+
+// erase removes the bars drawn last, leaving the cursor where they
+// started. The caller holds d.mu.
+func (d *progressDisplay) erase() {
+	if d.drawn > 0 {
+		fmt.Fprintf(d.out, "\x1b[%dA\x1b[J", d.drawn)
+		d.drawn = 0
+	}
+}
+
+// draw writes one bar per active download, at most as many as fit the
+// terminal, and a line counting the rest. The caller holds d.mu.
+func (d *progressDisplay) draw() {
+	visible := max(d.rows-2, 1)
+	var b strings.Builder
+	lines := 0
+	for i, t := range d.active {
+		if i == visible && len(d.active) > visible {
+			fmt.Fprintf(&b, "    ... and %d more\n", len(d.active)-visible)
+			lines++
+			break
+		}
+		b.WriteString(t.bar())
+		b.WriteByte('\n')
+		lines++
+	}
+	io.WriteString(d.out, b.String())
+	d.drawn = lines
+}
+
+// plain reports downloads that have been running a while as ordinary
+// status lines. The caller holds d.mu.
+func (d *progressDisplay) plain() {
+	now := time.Now()
+	for _, t := range d.active {
+		if now.Sub(t.lastPlain) < progressPlainEvery {
+			continue
+		}
+		t.lastPlain = now
+		if t.total > 0 {
+			fmt.Fprintf(d.out, "[*] %s: %d%% of %s\n", t.name, t.read*100/t.total, formatSize(t.total))
+		} else {
+			fmt.Fprintf(d.out, "[*] %s: %s\n", t.name, formatSize(t.read))
+		}
+	}
+}
+
+func (t *transfer) bar() string {
+	name := t.name
+	if len(name) > 24 {
+		name = name[:23] + "~"
+	}
+	if t.total <= 0 {
+		return fmt.Sprintf("%-24s [%s] %s", name, strings.Repeat("?", progressBarWidth), formatSize(t.read))
+	}
+	done := int(min(t.read*progressBarWidth/t.total, progressBarWidth))
+	fill := strings.Repeat("=", done)
+	if done < progressBarWidth {
+		fill += ">" + strings.Repeat(" ", progressBarWidth-done-1)
+	}
+	return fmt.Sprintf("%-24s [%s] %3d%%  %s / %s", name, fill, min(t.read*100/t.total, 100),
+		formatSize(t.read), formatSize(t.total))
+}
+
+// progressWriter clears the bars before passing a write through, so
+// status lines scroll above them; the next redraw puts the bars back.
+type progressWriter struct {
+	d *progressDisplay
+	w io.Writer
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.d.mu.Lock()
+	defer p.d.mu.Unlock()
+	p.d.erase()
+	return p.w.Write(b)
+}
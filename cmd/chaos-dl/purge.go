@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// purgeIndexCache deletes everything derived from the index, and the
+// bloom filter derived from the data, leaving the downloaded programs in
+// chaos/ alone: the cached index and its validators, a partial index
+// download, and the -save-index-history snapshots. The snapshots cannot
+// be fetched again, so removing them needs -yes or confirmation.
+func purgeIndexCache() error {
+	files := []string{cacheFile, cacheFile + ".tmp", indexMetaPath(), bloomFile}
+
+	historySize := int64(0)
+	if fileExists(historyDir) {
+		historySize = dirSize(historyDir)
+		entries, _ := os.ReadDir(historyDir)
+		if len(entries) > 0 && !opts.yes && !confirm(fmt.Sprintf("Delete %d index snapshots in %s?", len(entries), historyDir)) {
+			fmt.Fprintln(statusOut, "[*] Keeping the index snapshots (use -yes to delete them without asking)")
+			historySize = -1
+		}
+	}
+
+	removed, freed := 0, int64(0)
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		fmt.Fprintf(statusOut, "[-] Removed %s (%s)\n", path, formatSize(info.Size()))
+	}
+	if historySize >= 0 && fileExists(historyDir) {
+		if err := os.RemoveAll(historyDir); err != nil {
+			return err
+		}
+		removed++
+		freed += historySize
+		fmt.Fprintf(statusOut, "[-] Removed %s (%s)\n", historyDir, formatSize(historySize))
+	}
+
+	if removed == 0 {
+		fmt.Fprintln(statusOut, "[*] No index cache to purge")
+		return nil
+	}
+	fmt.Fprintf(statusOut, "[*] Purged %s of index cache; downloaded data in %s was kept\n", formatSize(freed), chaosDir)
+	return nil
+}
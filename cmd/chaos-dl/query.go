@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type queryResult struct {
+	file       string
+	matchCount int
+}
+
+// Match is one line of query output.
+type Match struct {
+	Program   string
+	Subdomain string
+	Line      int
+	IPs       []string
+}
+
+func (m Match) record() record {
+	sub := highlight(m.Subdomain)
+	rec := record{
+		Text: sub,
+		Fields: []field{
+			{"program", m.Program},
+			{"subdomain", m.Subdomain},
+		},
+	}
+	if opts.showIPs {
+		rec.Text = sub + "," + strings.Join(m.IPs, ",")
+		rec.Fields = append(rec.Fields, field{"ips", m.IPs})
+	}
+	if opts.printFormat != "" {
+		rec.Text = strings.NewReplacer(
+			"{program}", m.Program,
+			"{subdomain}", sub,
+			"{lineno}", strconv.Itoa(m.Line),
+			"{ips}", strings.Join(m.IPs, ","),
+		).Replace(opts.printFormat)
+	}
+	return rec
+}
+
+// printFormatEscapes turns the escapes a shell leaves in a -print-format
+// template into the characters they name.
+var printFormatEscapes = strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\\`, `\`)
+
+func programOf(path string) string {
+	if name, ok := flatProgram(filepath.Base(path)); ok && filepath.Base(path) != "subdomains.txt" {
+		return name
+	}
+	return filepath.Base(filepath.Dir(path))
+}
+
+func parallelQuery(terms []string, workers int) {
+	w, closeTee, err := queryWriter()
+	if err != nil {
+		fmt.Fprintf(errOut, "[-] -tee: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeTee()
+
+	m := newQueryMatcher(terms)
+	if opts.color && opts.format == "text" && opts.tee == "" {
+		highlighter, _ = m.(spanMatcher)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failures := &scanFailures{}
+	defer failures.report()
+
+	var matches <-chan Match
+	switch {
+	case opts.in != "":
+		matches = readFile(ctx, subdomainsPath(opts.in), m, failures)
+	case opts.all:
+		matches = scanAll(ctx, m, workers, failures)
+	default:
+		best := bestFile(ctx, m, workers, failures)
+		if best == "" {
+			return
+		}
+		matches = readFile(ctx, best, nil, failures)
+	}
+	if where != nil {
+		matches = filterMatches(ctx, matches, where)
+	}
+	// The best file is read whole, so the label range is applied to the
+	// lines as well as to choosing the file.
+	if opts.minLabels > 0 || opts.maxLabels > 0 {
+		matches = filterMatches(ctx, matches, labelMatcher{min: opts.minLabels, max: opts.maxLabels}.Match)
+	}
+	if opts.resolve {
+		matches = resolveMatches(ctx, matches, workers)
+	}
+	if opts.countDistinct {
+		touchPrograms(countDistinct(matches, w))
+		return
+	}
+	if opts.labelsOnly {
+		used, err := labelsOnly(matches, w)
+		if err != nil {
+			fmt.Fprintf(errOut, "[-] -labels-only: %v\n", err)
+			os.Exit(1)
+		}
+		touchPrograms(used)
+		return
+	}
+
+	out, _ := newFormatter(opts.format, w)
+	out = limitOutput(out, cancel)
+	report := newQueryReport(queryTitle(terms))
+	used := writeMatches(matches, out, report)
+	out.Close()
+	if opts.report != "" {
+		if err := report.write(opts.report); err != nil {
+			fmt.Fprintf(errOut, "[-] Write report: %v\n", err)
+		}
+	}
+
+	touchPrograms(used)
+}
+
+// queryWriter returns where query results go: stdout, and with -tee
+// also the named file. The returned function closes the file.
+func queryWriter() (io.Writer, func(), error) {
+	if opts.tee == "" {
+		return os.Stdout, func() {}, nil
+	}
+	f, err := os.Create(opts.tee)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.MultiWriter(os.Stdout, f), func() {
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(errOut, "[-] -tee: %v\n", err)
+		}
+	}, nil
+}
+
+// flushInterval is how often streamed query output is pushed to stdout
+// while matches are still arriving.
+const flushInterval = 200 * time.Millisecond
+
+// writeMatches writes matches to out as they arrive, flushing
+// periodically so results show up while the scan is still running. It is
+// the only writer of out, so lines never interleave. It returns the
+// programs that produced output.
+func writeMatches(matches <-chan Match, out formatter, report *queryReport) map[string]bool {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	used := make(map[string]bool)
+	if opts.groupByDomain {
+		var all []Match
+		for m := range matches {
+			used[m.Program] = true
+			report.add(m)
+			all = append(all, m)
+		}
+		writeGrouped(all, out)
+		return used
+	}
+
+	pending := false
+	for {
+		select {
+		case m, ok := <-matches:
+			if !ok {
+				return used
+			}
+			used[m.Program] = true
+			report.add(m)
+			out.Write(m.record())
+			pending = true
+		case <-ticker.C:
+			if pending {
+				out.Flush()
+				pending = false
+			}
+		}
+	}
+}
+
+// writeGrouped writes matches sorted by registrable domain and then by
+// hostname, for -group-by-domain. Text output gets a header line per
+// domain; the structured formats get a domain field instead.
+func writeGrouped(matches []Match, out formatter) {
+	domains := make([]string, len(matches))
+	for i, m := range matches {
+		host, _ := splitRecord(m.Subdomain)
+		domains[i] = registrableDomain(host)
+	}
+	idx := make([]int, len(matches))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		i, j := idx[a], idx[b]
+		if domains[i] != domains[j] {
+			return domains[i] < domains[j]
+		}
+		return matches[i].Subdomain < matches[j].Subdomain
+	})
+
+	prev := ""
+	for n, i := range idx {
+		rec := matches[i].record()
+		if opts.format == "text" {
+			if domains[i] != prev {
+				header := "# " + domains[i]
+				if n > 0 {
+					header = "\n" + header
+				}
+				out.Write(record{Text: header})
+				prev = domains[i]
+			}
+		} else {
+			rec.Fields = append(rec.Fields, field{"domain", domains[i]})
+		}
+		out.Write(rec)
+	}
+}
+
+// touchPrograms updates the access times used by -cache-limit eviction.
+func touchPrograms(used map[string]bool) {
+	if len(used) == 0 {
+		return
+	}
+	programs := make([]string, 0, len(used))
+	for p := range used {
+		programs = append(programs, p)
+	}
+	mf := loadManifest()
+	mf.touch(programs)
+	mf.save()
+}
+
+// filterMatches passes on the matches whose subdomain satisfies keep.
+func filterMatches(ctx context.Context, in <-chan Match, keep func(string) bool) <-chan Match {
+	out := make(chan Match, cap(in))
+	go func() {
+		defer close(out)
+		for m := range in {
+			if !keep(m.Subdomain) {
+				continue
+			}
+			select {
+			case out <- m:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out
+}
+
+// bestFile returns the subdomains.txt with the most lines matching m, or
+// "" if nothing matches or ctx is canceled.
+func bestFile(ctx context.Context, m Matcher, workers int, failures *scanFailures) string {
+	// File jobs are fed by the walker as it discovers them
+	fileJobs := make(chan string, workers*2)
+	results := make(chan queryResult, workers)
+
+	// Start query workers
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range fileJobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				count, err := countMatches(ctx, path, m)
+				if err != nil {
+					failures.record(path, err)
+				}
+				if count > 0 && count >= opts.minMatches {
+					results <- queryResult{file: path, matchCount: count}
+				}
+			}
+		}()
+	}
+
+	// Feed file jobs
+	go walkSubdomainFiles(chaosDir, workers, fileJobs)
+
+	// Close results when workers done
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Find best match
+	var best queryResult
+	for result := range results {
+		if result.matchCount > best.matchCount {
+			best = result
+		}
+	}
+	return best.file
+}
+
+// readFile streams the lines of path matching m (every line if m is nil).
+func readFile(ctx context.Context, path string, m Matcher, failures *scanFailures) <-chan Match {
+	matches := make(chan Match, 64)
+	go func() {
+		defer close(matches)
+		if err := scanLines(ctx, path, m, matches); err != nil {
+			failures.record(path, err)
+		}
+	}()
+	return matches
+}
+
+// Query streams the lines matching m from every subdomains.txt under dir
+// as they are found. A file that cannot be read completely produces a
+// *ScanError on the error channel and the scan continues with the other
+// files. Both channels are closed when the scan finishes or ctx is
+// canceled; callers must receive from both.
+func Query(ctx context.Context, dir string, m Matcher) (<-chan Match, <-chan error) {
+	return queryDir(ctx, dir, m, runtime.NumCPU())
+}
+
+func queryDir(ctx context.Context, dir string, m Matcher, workers int) (<-chan Match, <-chan error) {
+	fileJobs := make(chan string, workers*2)
+	matches := make(chan Match, workers*64)
+	errs := make(chan error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range fileJobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := scanLines(ctx, path, m, matches); err != nil {
+					select {
+					case errs <- &ScanError{Path: path, Err: err}:
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+
+	go walkSubdomainFiles(dir, workers, fileJobs)
+
+	go func() {
+		wg.Wait()
+		close(matches)
+		close(errs)
+	}()
+	return matches, errs
+}
+
+// scanAll streams the lines matching m from every downloaded program,
+// recording unreadable files in failures.
+func scanAll(ctx context.Context, m Matcher, workers int, failures *scanFailures) <-chan Match {
+	matches, errs := queryDir(ctx, chaosDir, m, workers)
+	go func() {
+		for err := range errs {
+			var se *ScanError
+			if errors.As(err, &se) {
+				failures.record(se.Path, se.Err)
+			}
+		}
+	}()
+	return matches
+}
+
+// scanLines sends the lines of path matching m (all lines if m is nil)
+// until ctx is canceled. With -min-matches the first matches are held
+// back until the file has enough of them, so files with fewer send
+// nothing. A non-nil error means the file could not be read completely.
+func scanLines(ctx context.Context, path string, m Matcher, matches chan<- Match) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	program := programOf(path)
+	scanner := newLineScanner(f)
+
+	var held []Match
+	hold := m != nil && opts.minMatches > 1
+	send := func(match Match) bool {
+		select {
+		case matches <- match:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		if lineno%ctxCheckLines == 0 && ctx.Err() != nil {
+			return nil
+		}
+		line := scanner.Text()
+		if m != nil && !m.Match(line) {
+			continue
+		}
+		match := Match{Program: program, Subdomain: line, Line: lineno}
+		if hold {
+			held = append(held, match)
+			if len(held) < opts.minMatches {
+				continue
+			}
+			hold = false
+			for _, h := range held {
+				if !send(h) {
+					return nil
+				}
+			}
+			held = nil
+			continue
+		}
+		if !send(match) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// ctxCheckLines is how often, in lines, scans poll for cancellation
+// while no matches are being sent.
+const ctxCheckLines = 4096
+
+// countMatches returns the number of lines in path matching m. On error
+// or cancellation the count covers only the lines read so far.
+func countMatches(ctx context.Context, path string, m Matcher) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	scanner := newLineScanner(f)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		if lineno%ctxCheckLines == 0 && ctx.Err() != nil {
+			return count, nil
+		}
+		if m.Match(scanner.Text()) {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+// newLineScanner returns a line scanner whose maximum line length is
+// -max-line.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(64*1024, opts.maxLine)), opts.maxLine)
+	return scanner
+}
+
+// scanFailures counts the files a scan could not read completely. It is
+// safe for concurrent use.
+type scanFailures struct {
+	n atomic.Int64
+}
+
+// record warns about a file whose scan stopped early, so the results
+// drawn from it are incomplete.
+func (s *scanFailures) record(path string, err error) {
+	s.n.Add(1)
+	if errors.Is(err, bufio.ErrTooLong) {
+		fmt.Fprintf(errOut, "[!] %s: line longer than -max-line (%d bytes), results incomplete\n", path, opts.maxLine)
+		return
+	}
+	fmt.Fprintf(errOut, "[!] %s: %v, results incomplete\n", path, err)
+}
+
+func (s *scanFailures) report() {
+	if n := s.n.Load(); n > 0 {
+		fmt.Fprintf(errOut, "[!] %d files could not be fully read; results are incomplete\n", n)
+	}
+}
+
+// limitOutput applies -head and -tail to out. Once -head lines have been
+// written, or out fails (e.g. the reader of a pipe went away), stop is
+// called so producers can quit early.
+func limitOutput(out formatter, stop func()) formatter {
+	out = &stopOnError{formatter: out, stop: stop}
+	switch {
+	case opts.head > 0:
+		return &headFormatter{formatter: out, n: opts.head, stop: stop}
+	case opts.tail > 0:
+		return &tailFormatter{formatter: out, n: opts.tail}
+	}
+	return out
+}
+
+// stopOnError calls stop and drops further records after the first
+// failed write.
+type stopOnError struct {
+	formatter
+	stop   func()
+	failed bool
+}
+
+func (f *stopOnError) Write(rec record) error {
+	if f.failed {
+		return nil
+	}
+	if err := f.formatter.Write(rec); err != nil {
+		f.failed = true
+		f.stop()
+		return err
+	}
+	return nil
+}
+
+type headFormatter struct {
+	formatter
+	n, written int
+	stop       func()
+}
+
+func (f *headFormatter) Write(rec record) error {
+	if f.written >= f.n {
+		return nil
+	}
+	f.written++
+	if f.written == f.n {
+		f.stop()
+	}
+	return f.formatter.Write(rec)
+}
+
+// tailFormatter keeps the last n records in a ring and writes them on
+// Close.
+type tailFormatter struct {
+	formatter
+	n     int
+	ring  []record
+	start int
+}
+
+func (f *tailFormatter) Write(rec record) error {
+	if len(f.ring) < f.n {
+		f.ring = append(f.ring, rec)
+		return nil
+	}
+	f.ring[f.start] = rec
+	f.start = (f.start + 1) % f.n
+	return nil
+}
+
+func (f *tailFormatter) Close() error {
+	for i := range f.ring {
+		if err := f.formatter.Write(f.ring[(f.start+i)%len(f.ring)]); err != nil {
+			return err
+		}
+	}
+	return f.formatter.Close()
+}
+
+func validateLimits() error {
+	if opts.head > 0 && opts.tail > 0 {
+		return fmt.Errorf("-head and -tail are mutually exclusive")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// rateLimitRetries is how many times a download answered with 429 is
+	// tried again before it counts as failed.
+	rateLimitRetries = 3
+
+	// maxRetryAfter caps the wait a Retry-After header can impose, so a
+	// misconfigured server cannot stall a run for hours.
+	maxRetryAfter = 2 * time.Minute
+
+	// rateLimitBackoff is the first wait when a 429 carries no usable
+	// Retry-After; it doubles on each further attempt.
+	rateLimitBackoff = 2 * time.Second
+)
+
+// parseRetryAfter reads a Retry-After header in either the delay-seconds
+// or the HTTP-date form, relative to now. A date in the past means no
+// wait.
+func parseRetryAfter(h string, now time.Time) (time.Duration, bool) {
+	h = strings.TrimSpace(h)
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(h)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// fetchZipRateLimited is fetchZip, trying again after the server's
+// Retry-After (capped at maxRetryAfter) when it answers 429 Too Many
+// Requests. Other failures are returned at once. With
+// -timeout-retry-budget, retries stop once that long has passed since
+// the first failed attempt, and a retry still running then is cut off.
+func fetchZipRateLimited(ctx context.Context, p Program) (string, int64, error) {
+	backoff := rateLimitBackoff
+	var deadline time.Time
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if !deadline.IsZero() {
+			attemptCtx, cancel = context.WithDeadline(ctx, deadline)
+		}
+		path, n, err := fetchZip(attemptCtx, p)
+		cancel()
+		var de *DownloadError
+		if err == nil || attempt == rateLimitRetries ||
+			!errors.As(err, &de) || de.StatusCode != http.StatusTooManyRequests {
+			return path, n, err
+		}
+		if opts.retryBudget > 0 && deadline.IsZero() {
+			deadline = time.Now().Add(opts.retryBudget)
+		}
+
+		wait := de.RetryAfter
+		if wait <= 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		wait = min(wait, maxRetryAfter)
+		if !deadline.IsZero() && time.Until(deadline) < wait {
+			reportProgram(p.Name, warnf("[!] %s: rate limited (429), retry budget of %s exhausted", p.Name, opts.retryBudget).
+				with(field{"url", p.URL}, field{"status", de.StatusCode}))
+			return path, n, err
+		}
+		reportProgram(p.Name, warnf("[!] %s: rate limited (429), retrying in %s", p.Name, wait.Round(time.Second)).
+			with(field{"url", p.URL}, field{"status", de.StatusCode}, field{"wait", wait.Seconds()}))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", 0, &DownloadError{Program: p.Name, Err: ctx.Err()}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+type statusLevel int
+
+const (
+	levelInfo statusLevel = iota
+	levelWarning
+	levelError
+)
+
+// statusLine is one line of per-program download output. Fields are
+// only shown by -log-format json.
+type statusLine struct {
+	level  statusLevel
+	text   string
+	fields []field
+}
+
+func infof(format string, args ...any) statusLine {
+	return statusLine{level: levelInfo, text: fmt.Sprintf(format, args...)}
+}
+
+func warnf(format string, args ...any) statusLine {
+	return statusLine{level: levelWarning, text: fmt.Sprintf(format, args...)}
+}
+
+func errorf(format string, args ...any) statusLine {
+	return statusLine{level: levelError, text: fmt.Sprintf(format, args...)}
+}
+
+// with attaches structured fields to l.
+func (l statusLine) with(fields ...field) statusLine {
+	l.fields = append(l.fields, fields...)
+	return l
+}
+
+var reportMu sync.Mutex
+
+// reportProgram writes the status lines for one program in a single
+// block so concurrent workers never interleave them. With -ci=github the
+// block becomes a collapsible log group, and warnings and errors are also
+// emitted as workflow annotations.
+func reportProgram(program string, lines ...statusLine) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+
+	if reportOrder != nil && reportOrder.hold(program, lines) {
+		return
+	}
+	writeProgram(program, lines)
+}
+
+func writeProgram(program string, lines []statusLine) {
+	if opts.quietSuccess {
+		lines = dropSuccess(lines)
+		if len(lines) == 0 {
+			return
+		}
+	}
+	if logStatus, ok := jsonLogOf(statusOut); ok {
+		logErr, _ := jsonLogOf(errOut)
+		for _, l := range lines {
+			logf := logStatus
+			if l.level != levelInfo {
+				logf = logErr
+			}
+			_, msg := splitLevel(l.text)
+			logf(statusLevelName(l.level), msg, append([]field{{"program", program}}, l.fields...)...)
+		}
+		return
+	}
+	if opts.ci != "github" {
+		for _, l := range lines {
+			if l.level == levelInfo {
+				fmt.Fprintln(statusOut, l.text)
+			} else {
+				fmt.Fprintln(errOut, l.text)
+			}
+		}
+		return
+	}
+
+	fmt.Fprintf(statusOut, "::group::%s\n", program)
+	for _, l := range lines {
+		fmt.Fprintln(statusOut, l.text)
+	}
+	fmt.Fprintln(statusOut, "::endgroup::")
+	for _, l := range lines {
+		switch l.level {
+		case levelWarning:
+			fmt.Fprintf(statusOut, "::warning title=%s::%s\n", ghEscapeProperty(program), ghEscape(l.text))
+		case levelError:
+			fmt.Fprintf(statusOut, "::error title=%s::%s\n", ghEscapeProperty(program), ghEscape(l.text))
+		}
+	}
+}
+
+// dropSuccess removes the "[+]" lines of a successful program for
+// -quiet-success, keeping skips, warnings and errors.
+func dropSuccess(lines []statusLine) []statusLine {
+	var kept []statusLine
+	for _, l := range lines {
+		if l.level == levelInfo && strings.HasPrefix(l.text, "[+]") {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	return kept
+}
+
+// outputOrder holds back per-program status for -ordered until every
+// program before it in the download order has finished, so the log
+// follows the input list instead of completion order.
+type outputOrder struct {
+	names   []string
+	index   map[string]int
+	pending map[string][]statusLine
+	done    map[string]bool
+	next    int
+}
+
+// reportOrder is set while an -ordered download run is in progress.
+var reportOrder *outputOrder
+
+func startOrderedReport(programs []Program) {
+	o := &outputOrder{
+		index:   make(map[string]int, len(programs)),
+		pending: make(map[string][]statusLine),
+		done:    make(map[string]bool),
+	}
+	for _, p := range programs {
+		o.index[p.Name] = len(o.names)
+		o.names = append(o.names, p.Name)
+	}
+	reportMu.Lock()
+	reportOrder = o
+	reportMu.Unlock()
+}
+
+// hold buffers lines for a program of the run. Called with reportMu held.
+func (o *outputOrder) hold(program string, lines []statusLine) bool {
+	if _, ok := o.index[program]; !ok {
+		return false
+	}
+	o.pending[program] = append(o.pending[program], lines...)
+	return true
+}
+
+// programDone marks program finished and writes the status of every
+// finished program at the front of the order.
+func programDone(program string) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+
+	o := reportOrder
+	if o == nil {
+		return
+	}
+	o.done[program] = true
+	for o.next < len(o.names) && o.done[o.names[o.next]] {
+		o.flush(o.names[o.next])
+		o.next++
+	}
+}
+
+// finishOrderedReport writes whatever is still held, in order, for
+// programs that never finished because the run was aborted.
+func finishOrderedReport() {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+
+	if o := reportOrder; o != nil {
+		for ; o.next < len(o.names); o.next++ {
+			o.flush(o.names[o.next])
+		}
+	}
+	reportOrder = nil
+}
+
+func (o *outputOrder) flush(program string) {
+	if lines := o.pending[program]; len(lines) > 0 {
+		writeProgram(program, lines)
+	}
+	delete(o.pending, program)
+}
+
+// ghEscape encodes the characters GitHub Actions treats specially in
+// workflow command messages.
+func ghEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// ghEscapeProperty is ghEscape for workflow command property values
+// such as title=, where ':' and ',' end the value.
+func ghEscapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// detectCI returns the -ci default for the current environment.
+func detectCI() string {
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		return "github"
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// newResolver returns the system resolver, or one that sends every query
+// to addr when a custom -resolver is configured.
+func newResolver(addr string) *net.Resolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// resolveMatches looks up every match with a bounded pool of workers and
+// passes on only those that resolve, with their addresses filled in.
+func resolveMatches(ctx context.Context, in <-chan Match, workers int) <-chan Match {
+	resolver := newResolver(opts.resolver)
+	out := make(chan Match, workers*2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range in {
+				if ctx.Err() != nil {
+					continue
+				}
+				lctx, cancel := context.WithTimeout(ctx, opts.resolveTimeout)
+				addrs, err := resolver.LookupHost(lctx, m.Subdomain)
+				cancel()
+				if err != nil || len(addrs) == 0 {
+					continue
+				}
+				m.IPs = addrs
+				select {
+				case out <- m:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	// Close out when workers done
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// runState records which programs a download run has finished, in
+// chaos/run-state.jsonl, so an interrupted run can be continued with
+// -resume-run. The file starts with a line holding the run's start time
+// and gets one line appended per completed program, so saving costs the
+// same however far the run has got. It is removed once a run finishes
+// without failures. It is safe for concurrent use.
+type runState struct {
+	mu        sync.Mutex
+	path      string
+	f         *os.File
+	resumed   bool
+	Started   time.Time
+	Completed map[string]bool
+}
+
+// runStateLine is one line of the state file: the header or a completed
+// program.
+type runStateLine struct {
+	Started   *time.Time `json:"started,omitempty"`
+	Completed string     `json:"completed,omitempty"`
+}
+
+// loadRunState returns the state of the interrupted run for -resume-run,
+// or a fresh state otherwise.
+func loadRunState() *runState {
+	s := &runState{
+		path:      filepath.Join(chaosDir, "run-state.jsonl"),
+		Started:   time.Now().UTC(),
+		Completed: make(map[string]bool),
+	}
+	if !opts.resumeRun {
+		return s
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		fmt.Fprintln(statusOut, "[*] No interrupted run to resume, starting a new one")
+		return s
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line runStateLine
+		// An interrupted write leaves at most a torn last line.
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+		if line.Started != nil {
+			s.Started = *line.Started
+		}
+		if line.Completed != "" {
+			s.Completed[line.Completed] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(errOut, "[!] Ignoring unreadable %s: %v\n", s.path, err)
+		s.Completed = make(map[string]bool)
+		return s
+	}
+	s.resumed = true
+	return s
+}
+
+// skipCompleted drops the programs an interrupted run already finished.
+func (s *runState) skipCompleted(programs []Program, summary *runSummary) []Program {
+	if len(s.Completed) == 0 {
+		return programs
+	}
+	var kept []Program
+	for _, p := range programs {
+		if !s.Completed[p.Name] {
+			kept = append(kept, p)
+			continue
+		}
+		reportProgram(p.Name, infof("[=] %s (done in the interrupted run)", p.Name))
+		summary.add(programSummary{Name: p.Name, Status: "resumed"})
+	}
+	fmt.Fprintf(statusOut, "[*] Resuming run started %s: %d done, %d left\n",
+		s.Started.Local().Format("2006-01-02 15:04"), len(programs)-len(kept), len(kept))
+	return kept
+}
+
+// complete records program as finished and appends it to the state
+// file, opening the file on first use.
+func (s *runState) complete(program string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Completed[program] = true
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			fmt.Fprintf(errOut, "[-] Save run state: %v\n", err)
+			return
+		}
+	}
+	data, err := json.Marshal(runStateLine{Completed: program})
+	if err != nil {
+		return
+	}
+	if _, err := s.f.Write(append(data, '\n')); err != nil {
+		fmt.Fprintf(errOut, "[-] Save run state: %v\n", err)
+	}
+}
+
+// open appends to the file of a resumed run, or starts a new one with
+// the header line.
+func (s *runState) open() error {
+	if s.resumed {
+		f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			s.f = f
+			return nil
+		}
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(runStateLine{Started: &s.Started})
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+// close closes the state file, keeping it for -resume-run.
+func (s *runState) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil {
+		if err := s.f.Close(); err != nil {
+			fmt.Fprintf(errOut, "[-] Save run state: %v\n", err)
+		}
+		s.f = nil
+	}
+}
+
+// clear removes the state file once a run has nothing left to resume.
+func (s *runState) clear() {
+	s.close()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(errOut, "[-] Remove run state: %v\n", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// urlExpiry returns when a signed URL stops being valid, from the query
+// parameters of the common signing schemes: Expires (CloudFront, S3 and
+// GCS V2), X-Amz-Date with X-Amz-Expires (S3 SigV4), X-Goog-Date with
+// X-Goog-Expires (GCS V4) and se with sig (Azure SAS). URLs without them
+// are not treated as signed.
+func urlExpiry(rawURL string) (time.Time, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return time.Time{}, false
+	}
+	q := make(map[string]string)
+	for k, v := range u.Query() {
+		if len(v) > 0 {
+			q[strings.ToLower(k)] = v[0]
+		}
+	}
+
+	for _, prefix := range []string{"x-amz-", "x-goog-"} {
+		date, okDate := q[prefix+"date"]
+		secs, okSecs := q[prefix+"expires"]
+		if !okDate || !okSecs {
+			continue
+		}
+		t, err := time.Parse("20060102T150405Z", date)
+		n, nerr := strconv.ParseInt(secs, 10, 64)
+		if err != nil || nerr != nil {
+			return time.Time{}, false
+		}
+		return t.Add(time.Duration(n) * time.Second), true
+	}
+	if v, ok := q["expires"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(n, 0), true
+		}
+	}
+	if v, ok := q["se"]; ok && q["sig"] != "" {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// urlExpired reports whether rawURL is signed and past its expiry.
+func urlExpired(rawURL string, now time.Time) bool {
+	exp, ok := urlExpiry(rawURL)
+	return ok && !exp.After(now)
+}
+
+// indexExpired reports whether any download URL in programs has expired,
+// meaning the cached index is too old to download from.
+func indexExpired(programs []Program) bool {
+	now := time.Now()
+	for _, p := range programs {
+		if urlExpired(p.URL, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// renewal refreshes the index at most once per run, for downloads whose
+// signed URL expired while the run was going.
+var renewal struct {
+	once sync.Once
+	urls map[string]string
+	err  error
+}
+
+// renewURL returns a fresh, unexpired URL for p from a refreshed index.
+func renewURL(p Program) (string, bool) {
+	renewal.once.Do(func() {
+		fmt.Fprintln(statusOut, "[*] Signed download URLs have expired, refreshing the index...")
+		if _, err := fetchIndex(); err != nil {
+			renewal.err = err
+			return
+		}
+		programs, err := loadIndex()
+		if err != nil {
+			renewal.err = err
+			return
+		}
+		renewal.urls = make(map[string]string, len(programs))
+		for _, p := range programs {
+			renewal.urls[p.Name] = p.URL
+		}
+	})
+	if renewal.err != nil {
+		return "", false
+	}
+	fresh, ok := renewal.urls[p.Name]
+	if !ok || fresh == p.URL || urlExpired(fresh, time.Now()) {
+		return "", false
+	}
+	return fresh, true
+}
+
+// fetchZipSigned is fetchZipRateLimited for index URLs that may be signed
+// with an expiry. An expired URL is swapped for the one in a refreshed
+// index, before the request or after a 4xx; a download that still fails
+// on an expired URL is reported as such.
+func fetchZipSigned(ctx context.Context, p Program) (string, int64, error) {
+	renewed := false
+	renew := func() bool {
+		if renewed {
+			return false
+		}
+		renewed = true
+		fresh, ok := renewURL(p)
+		if ok {
+			reportProgram(p.Name, warnf("[!] %s: signed URL expired, using the refreshed index", p.Name))
+			p.URL = fresh
+		}
+		return ok
+	}
+
+	if urlExpired(p.URL, time.Now()) {
+		renew()
+	}
+	for {
+		path, n, err := fetchZipRateLimited(ctx, p)
+		var de *DownloadError
+		if err == nil || !errors.As(err, &de) || de.StatusCode < 400 || de.StatusCode >= 500 ||
+			!urlExpired(p.URL, time.Now()) {
+			return path, n, err
+		}
+		if renew() {
+			continue
+		}
+		exp, _ := urlExpiry(p.URL)
+		return "", 0, &DownloadError{Program: p.Name, StatusCode: de.StatusCode,
+			Err: fmt.Errorf("status %d: %w at %s, refresh the index with -u", de.StatusCode, errURLExpired, exp.UTC().Format(time.RFC3339))}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// sizeLimit caps the bytes decompressed from one archive, for
+// -max-uncompressed. Readers from the same archive share the count, so
+// all of its entries are limited together. A zero max disables the
+// limit.
+type sizeLimit struct {
+	max int64
+	n   atomic.Int64
+}
+
+func newSizeLimit(max int64) *sizeLimit {
+	return &sizeLimit{max: max}
+}
+
+// declared fails early when the sizes an archive declares for its
+// entries already add up to more than the limit.
+func (l *sizeLimit) declared(total uint64) error {
+	if l.max > 0 && total > uint64(l.max) {
+		return fmt.Errorf("%w: entries declare %s, limit is %s", errTooLarge, formatSize(int64(min(total, 1<<62))), formatSize(l.max))
+	}
+	return nil
+}
+
+// reader counts what is read through r against the limit, failing once
+// it is exceeded. Declared sizes can lie, so this is the real check.
+func (l *sizeLimit) reader(r io.Reader) io.Reader {
+	if l.max <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, limit: l}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	limit *sizeLimit
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if lr.limit.n.Add(int64(n)) > lr.limit.max {
+		return n, fmt.Errorf("%w: more than %s decompressed", errTooLarge, formatSize(lr.limit.max))
+	}
+	return n, err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// programSummary is the outcome of one program in a download run.
+type programSummary struct {
+	Name            string  `json:"name"`
+	Status          string  `json:"status"`
+	Bytes           int64   `json:"bytes,omitempty"`
+	Subdomains      int     `json:"subdomains,omitempty"`
+	DownloadSeconds float64 `json:"download_seconds,omitempty"`
+	MBPerSecond     float64 `json:"mb_per_second,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	Category        string  `json:"category,omitempty"`
+}
+
+// errorExamples is how many program names each error group keeps.
+const errorExamples = 5
+
+// slowestShown is how many of the slowest downloads a summary lists.
+const slowestShown = 5
+
+// errorGroup aggregates the failures of one category.
+type errorGroup struct {
+	Count    int      `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// runSummary collects the outcome of a download run for -summary-json.
+// It is safe for concurrent use.
+type runSummary struct {
+	mu sync.Mutex
+
+	Started         time.Time              `json:"started"`
+	Finished        time.Time              `json:"finished"`
+	DurationSeconds float64                `json:"duration_seconds"`
+	Selected        int                    `json:"selected"`
+	Success         int                    `json:"success"`
+	Failed          int                    `json:"failed"`
+	Aborted         bool                   `json:"aborted"`
+	TotalBytes      int64                  `json:"total_bytes"`
+	TotalSubdomains int                    `json:"total_subdomains"`
+	Extracted       int                    `json:"extracted"`
+	Errors          map[string]*errorGroup `json:"errors"`
+	Slowest         []string               `json:"slowest,omitempty"`
+	Programs        []programSummary       `json:"programs"`
+}
+
+func newRunSummary(selected int) *runSummary {
+	return &runSummary{
+		Started:  time.Now().UTC(),
+		Selected: selected,
+		Errors:   make(map[string]*errorGroup),
+	}
+}
+
+func (s *runSummary) add(ps programSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ps.Bytes > 0 && ps.DownloadSeconds > 0 {
+		ps.MBPerSecond = float64(ps.Bytes) / 1e6 / ps.DownloadSeconds
+	}
+	s.TotalBytes += ps.Bytes
+	s.TotalSubdomains += ps.Subdomains
+	if ps.Status == "ok" {
+		s.Extracted++
+	}
+	if ps.Category != "" {
+		g, ok := s.Errors[ps.Category]
+		if !ok {
+			g = &errorGroup{}
+			s.Errors[ps.Category] = g
+		}
+		g.Count++
+		if len(g.Examples) < errorExamples {
+			g.Examples = append(g.Examples, ps.Name)
+		}
+	}
+	s.Programs = append(s.Programs, ps)
+}
+
+// failure records a program that failed with err at the given stage.
+func (s *runSummary) failure(name, status string, err error) {
+	s.add(programSummary{Name: name, Status: status, Error: err.Error(), Category: errorCategory(err)})
+}
+
+// failures returns how many programs of the run failed.
+func (s *runSummary) failures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, g := range s.Errors {
+		n += g.Count
+	}
+	return n
+}
+
+func (s *runSummary) finish(success, failed int, aborted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Finished = time.Now().UTC()
+	s.DurationSeconds = s.Finished.Sub(s.Started).Seconds()
+	s.Success = success
+	s.Failed = failed
+	s.Aborted = aborted
+	for _, ps := range s.slowest() {
+		s.Slowest = append(s.Slowest, ps.Name)
+	}
+}
+
+// slowest returns up to slowestShown downloads with the lowest
+// throughput. The caller holds s.mu.
+func (s *runSummary) slowest() []programSummary {
+	var timed []programSummary
+	for _, ps := range s.Programs {
+		if ps.MBPerSecond > 0 {
+			timed = append(timed, ps)
+		}
+	}
+	sort.Slice(timed, func(i, j int) bool { return timed[i].MBPerSecond < timed[j].MBPerSecond })
+	if len(timed) > slowestShown {
+		timed = timed[:slowestShown]
+	}
+	return timed
+}
+
+// printSlowest writes the slowest downloads of the run, for -verbose.
+func (s *runSummary) printSlowest(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slow := s.slowest()
+	if len(slow) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "[*] Slowest downloads:")
+	for _, ps := range slow {
+		fmt.Fprintf(w, "    %-24s %8.2f MB/s  (%s in %s)\n", ps.Name, ps.MBPerSecond, formatSize(ps.Bytes),
+			time.Duration(ps.DownloadSeconds*float64(time.Second)).Round(time.Millisecond))
+	}
+}
+
+// totals returns the number of subdomains extracted, the bytes
+// downloaded and the number of programs extracted.
+func (s *runSummary) totals() (int, int64, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.TotalSubdomains, s.TotalBytes, s.Extracted
+}
+
+// formatCount renders n with thousands separators.
+func formatCount(n int) string {
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	s := fmt.Sprint(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// printErrorReport writes the failures of the run grouped by category,
+// largest group first.
+func (s *runSummary) printErrorReport(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.Errors) == 0 {
+		return
+	}
+	categories := make([]string, 0, len(s.Errors))
+	for c := range s.Errors {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := s.Errors[categories[i]], s.Errors[categories[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return categories[i] < categories[j]
+	})
+
+	fmt.Fprintln(w, "[*] Errors by category:")
+	for _, c := range categories {
+		g := s.Errors[c]
+		examples := strings.Join(g.Examples, ", ")
+		if g.Count > len(g.Examples) {
+			examples += ", ..."
+		}
+		fmt.Fprintf(w, "    %-12s %5d  (%s)\n", c, g.Count, examples)
+	}
+}
+
+func (s *runSummary) writeJSON(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
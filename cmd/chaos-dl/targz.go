@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// extractTarGz is unzip for a gzip-compressed tarball. It honors -raw
+// like the zip path.
+func extractTarGz(r io.Reader, dest string, limit *sizeLimit) (int, bool, error) {
+	program := filepath.Base(dest)
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, false, &UnzipError{Program: program, Err: err}
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	if opts.raw {
+		return 0, false, extractRawTar(tr, dest, limit)
+	}
+
+	outFile, err := createSubdomainsFile(dest)
+	if err != nil {
+		return 0, false, &UnzipError{Program: program, Err: err}
+	}
+	defer outFile.Close()
+
+	writer, flush := extractWriter(outFile)
+	defer flush()
+	counter := &lineCounter{w: writer, max: opts.sample}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, false, &UnzipError{Program: program, Err: err}
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".txt") {
+			continue
+		}
+		if err := copyEntry(counter, limit.reader(tr)); errors.Is(err, errSampleDone) {
+			break
+		} else if err != nil {
+			flush()
+			discardPartial(outFile, err)
+			return 0, false, &UnzipError{Program: program, Entry: hdr.Name, Err: err}
+		}
+	}
+	if err := closeList(outFile, flush); err != nil {
+		return 0, false, &UnzipError{Program: program, Err: err}
+	}
+	return counter.lines, counter.cut, nil
+}
+
+// extractRawTar is extractRaw for a tar stream. Only directories and
+// regular files are written.
+func extractRawTar(tr *tar.Reader, dest string, limit *sizeLimit) error {
+	program := filepath.Base(dest)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &UnzipError{Program: program, Err: err}
+		}
+
+		path := filepath.Join(dest, hdr.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return &UnzipError{Program: program, Entry: hdr.Name, Err: fmt.Errorf("illegal path")}
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return &UnzipError{Program: program, Entry: hdr.Name, Err: err}
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return &UnzipError{Program: program, Entry: hdr.Name, Err: err}
+			}
+			out, err := os.Create(path)
+			if err != nil {
+				return &UnzipError{Program: program, Entry: hdr.Name, Err: err}
+			}
+			_, err = io.Copy(out, limit.reader(tr))
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return &UnzipError{Program: program, Entry: hdr.Name, Err: err}
+			}
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "os"
+
+// terminalRows reports that the terminal height is unknown on this
+// platform; callers fall back to $LINES.
+func terminalRows(f *os.File) int {
+	return 0
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+// expandPrograms resolves program names and glob patterns against the
+// programs with local data. A plain name that is not downloaded is an
+// error; a pattern matching nothing is not.
+func expandPrograms(patterns []string) ([]string, error) {
+	local, err := snapshotPrograms(chaosDir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, pat := range patterns {
+		if _, err := path.Match(pat, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", pat)
+		}
+		if _, ok := local[pat]; ok {
+			if !seen[pat] {
+				seen[pat] = true
+				names = append(names, pat)
+			}
+			continue
+		}
+		matched := false
+		for name := range local {
+			if ok, _ := path.Match(pat, name); ok {
+				matched = true
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+		if !matched && !hasGlob(pat) {
+			return nil, fmt.Errorf("program '%s' is not downloaded", pat)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func hasGlob(s string) bool {
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
+
+// unionPrograms prints the deduplicated, sorted union of the subdomains
+// of the programs matching patterns, or writes it to opts.out. Unlike
+// -merge-into nothing is stored.
+func unionPrograms(patterns []string) error {
+	if len(patterns) == 0 {
+		return fmt.Errorf("no programs given")
+	}
+	names, err := expandPrograms(patterns)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no downloaded programs match %v", patterns)
+	}
+
+	union := make(map[string]bool)
+	for _, name := range names {
+		set, err := readSet(subdomainsPath(name))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for s := range set {
+			union[s] = true
+		}
+	}
+	lines := make([]string, 0, len(union))
+	for s := range union {
+		lines = append(lines, s)
+	}
+	sort.Strings(lines)
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if opts.out != "" {
+		if f, err = os.Create(opts.out); err != nil {
+			return err
+		}
+		w = f
+	}
+	out, _ := newFormatter(opts.format, w)
+	for _, s := range lines {
+		out.Write(record{Text: s, Fields: []field{{"subdomain", s}}})
+	}
+	err = out.Close()
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		fmt.Fprintf(statusOut, "[+] Wrote %s subdomains from %d programs to %s\n", formatCount(len(lines)), len(names), opts.out)
+	}
+	return nil
+}
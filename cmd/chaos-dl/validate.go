@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// validateData checks every downloaded program for an empty or
+// unreadable subdomains.txt, lines that are not hostnames, and a line
+// count that disagrees with the manifest. It returns the names of the
+// programs with problems.
+func validateData() ([]string, error) {
+	// -raw and -no-unzip programs have no merged list to check.
+	programs, err := snapshotPrograms(chaosDir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(programs))
+	for name := range programs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mf := loadManifest()
+
+	var bad []string
+	checked := 0
+	for _, name := range names {
+		path := programs[name]
+		checked++
+
+		problems := validateFile(path)
+		if e, ok := mf.get(name); ok && len(problems) == 0 {
+			if lines, err := countLines(path); err == nil && lines != e.Subdomains {
+				problems = append(problems, fmt.Sprintf("%d lines, manifest recorded %d", lines, e.Subdomains))
+			}
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(statusOut, "[-] %s: %s\n", name, strings.Join(problems, "; "))
+			bad = append(bad, name)
+		}
+	}
+	sort.Strings(bad)
+	fmt.Fprintf(statusOut, "[*] Validated %d programs, %d with problems\n", checked, len(bad))
+	return bad, nil
+}
+
+// validateFile returns the problems found in one subdomains.txt.
+func validateFile(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer f.Close()
+
+	var problems []string
+	lines, invalid := 0, 0
+	example := ""
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		lines++
+		host, _ := splitRecord(scanner.Text())
+		if !isValidHostname(host) {
+			if invalid == 0 {
+				example = host
+			}
+			invalid++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		problems = append(problems, fmt.Sprintf("unreadable after line %d: %v", lines, err))
+	}
+	if lines == 0 {
+		problems = append(problems, "empty")
+	}
+	if invalid > 0 {
+		problems = append(problems, fmt.Sprintf("%d invalid lines (e.g. %q)", invalid, example))
+	}
+	return problems
+}
+
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	counter := &lineCounter{w: io.Discard}
+	_, err = io.Copy(counter, f)
+	return counter.lines, err
+}
+
+// fixPrograms re-downloads the programs named in bad that are in the
+// index, for -validate -fix. It returns how many are still bad: those
+// missing from the index and those whose re-download failed.
+func fixPrograms(programs []Program, bad []string, workers int) int {
+	want := make(map[string]bool, len(bad))
+	for _, name := range bad {
+		want[name] = true
+	}
+	var refetch []Program
+	for _, p := range programs {
+		if want[p.Name] {
+			refetch = append(refetch, p)
+			delete(want, p.Name)
+		}
+	}
+	for name := range want {
+		fmt.Fprintf(errOut, "[!] %s is not in the index, cannot re-download\n", name)
+	}
+	if len(refetch) == 0 {
+		return len(want)
+	}
+	opts.overwrite = true
+	return len(want) + parallelDownload(refetch, workers)
+}
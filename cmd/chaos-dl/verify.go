@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errExtractMismatch is returned by -verify-extract when the merged list
+// on disk does not hold as many lines as the archive entries.
+var errExtractMismatch = errors.New("extracted list does not match the archive")
+
+// verifyExtract compares the lines of the list written for program with
+// the lines the archive at src should have produced.
+func verifyExtract(src, program string) error {
+	want, err := archiveLines(src)
+	if err != nil {
+		return err
+	}
+	got, err := countLines(outputPath(program))
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: wrote %d lines, archive has %d", errExtractMismatch, got, want)
+	}
+	return nil
+}
+
+// archiveLines counts the lines of the .txt entries of the zip or
+// tar.gz at src, as the merge path writes them.
+func archiveLines(src string) (int, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 2)
+	if _, err := f.ReadAt(magic, 0); err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		tr := tar.NewReader(gz)
+		total := 0
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return total, nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".txt") {
+				continue
+			}
+			n, err := entryLines(tr)
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, zf := range r.File {
+		if zf.FileInfo().IsDir() || !strings.HasSuffix(zf.Name, ".txt") {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return 0, err
+		}
+		n, err := entryLines(rc)
+		rc.Close()
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// entryLines counts the lines copyEntry writes for r: its newlines, or
+// every scanned line when -strip-schemes or -strip-ports rewrites them.
+func entryLines(r io.Reader) (int, error) {
+	if !opts.stripSchemes && !opts.stripPorts {
+		counter := &lineCounter{w: io.Discard}
+		_, err := io.Copy(counter, r)
+		return counter.lines, err
+	}
+	n := 0
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		n++
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// walkSubdomainFiles sends the path of every subdomains.txt below root,
+// and of every flat-layout <name>.txt directly in it or in one of its
+// -shard directories, on out as soon as it is discovered, reading up to
+// workers directories concurrently. out is closed once the whole tree
+// has been walked.
+func walkSubdomainFiles(root string, workers int, out chan<- string) {
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+
+	var walkDir func(dir string)
+	walkDir = func(dir string) {
+		defer wg.Done()
+
+		sem <- struct{}{}
+		entries, err := os.ReadDir(dir)
+		<-sem
+		if err != nil {
+			return
+		}
+
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if e.IsDir() {
+				wg.Add(1)
+				go walkDir(path)
+			} else if e.Name() == "subdomains.txt" {
+				out <- path
+			} else if name, ok := flatProgram(e.Name()); ok && (dir == root || inShard(root, dir, name)) {
+				out <- path
+			}
+		}
+	}
+
+	wg.Add(1)
+	go walkDir(root)
+	wg.Wait()
+	close(out)
+}
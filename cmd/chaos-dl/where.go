@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A -where expression is a boolean predicate over the parts of a
+// hostname. It is parsed into a tree of closures once and evaluated per
+// line; there is no way to reach anything beyond the fields below.
+//
+//	host    string  the full hostname, lowercased
+//	first   string  the leftmost label
+//	tld     string  the rightmost label
+//	labels  int     number of labels
+//	depth   int     labels left of the registrable domain (labels - 2)
+//	len     int     length of host
+//	numeric bool    some label is all digits
+//	digits  bool    some label contains a digit
+//
+// Operators: == != < <= > >= contains startswith endswith, combined with
+// && || ! (or and, or, not) and parentheses. Strings are double-quoted.
+//
+//	-where 'labels > 3 && host endswith ".internal"'
+
+type hostParts struct {
+	host   string
+	labels []string
+}
+
+type whereExpr func(h *hostParts) any
+
+// whereNode is a compiled expression and its static type: 'b' bool,
+// 'i' int or 's' string.
+type whereNode struct {
+	eval whereExpr
+	typ  byte
+}
+
+var whereFields = map[string]whereNode{
+	"host":   {func(h *hostParts) any { return h.host }, 's'},
+	"first":  {func(h *hostParts) any { return h.labels[0] }, 's'},
+	"tld":    {func(h *hostParts) any { return h.labels[len(h.labels)-1] }, 's'},
+	"labels": {func(h *hostParts) any { return len(h.labels) }, 'i'},
+	"depth":  {func(h *hostParts) any { return max(len(h.labels)-2, 0) }, 'i'},
+	"len":    {func(h *hostParts) any { return len(h.host) }, 'i'},
+	"numeric": {func(h *hostParts) any {
+		for _, l := range h.labels {
+			if l != "" && strings.Trim(l, "0123456789") == "" {
+				return true
+			}
+		}
+		return false
+	}, 'b'},
+	"digits": {func(h *hostParts) any {
+		for _, l := range h.labels {
+			if strings.ContainsAny(l, "0123456789") {
+				return true
+			}
+		}
+		return false
+	}, 'b'},
+}
+
+// compileWhere parses expr into a predicate over hostnames. Type errors
+// are reported here rather than while scanning.
+func compileWhere(expr string) (func(string) bool, error) {
+	toks, err := tokenizeWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{toks: toks}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if n.typ != 'b' {
+		return nil, fmt.Errorf("expression is not boolean")
+	}
+
+	return func(line string) bool {
+		host := strings.ToLower(strings.TrimSpace(line))
+		return n.eval(&hostParts{host: host, labels: strings.Split(host, ".")}).(bool)
+	}, nil
+}
+
+type whereToken struct {
+	kind byte // 'i' identifier, 'n' number, 's' string, 'o' operator
+	text string
+}
+
+func tokenizeWhere(s string) ([]whereToken, error) {
+	var toks []whereToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			str, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s", s[i:j+1])
+			}
+			toks = append(toks, whereToken{'s', str})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			toks = append(toks, whereToken{'n', s[i:j]})
+			i = j
+		case unicode.IsLetter(rune(c)) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			toks = append(toks, whereToken{'i', s[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, o := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			toks = append(toks, whereToken{'o', op})
+			i += len(op)
+		}
+	}
+	return toks, nil
+}
+
+type whereParser struct {
+	toks []whereToken
+	pos  int
+}
+
+func (p *whereParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos].text
+	}
+	return ""
+}
+
+func (p *whereParser) accept(texts ...string) (string, bool) {
+	cur := p.peek()
+	for _, t := range texts {
+		if cur == t && p.pos < len(p.toks) && (p.toks[p.pos].kind == 'o' || p.toks[p.pos].kind == 'i') {
+			p.pos++
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		if left.typ != 'b' || right.typ != 'b' {
+			return left, fmt.Errorf("|| needs boolean operands")
+		}
+		l, r := left.eval, right.eval
+		left = whereNode{func(h *hostParts) any { return l(h).(bool) || r(h).(bool) }, 'b'}
+	}
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return left, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return right, err
+		}
+		if left.typ != 'b' || right.typ != 'b' {
+			return left, fmt.Errorf("&& needs boolean operands")
+		}
+		l, r := left.eval, right.eval
+		left = whereNode{func(h *hostParts) any { return l(h).(bool) && r(h).(bool) }, 'b'}
+	}
+}
+
+func (p *whereParser) parseNot() (whereNode, error) {
+	if _, ok := p.accept("!", "not"); ok {
+		n, err := p.parseNot()
+		if err != nil {
+			return n, err
+		}
+		if n.typ != 'b' {
+			return n, fmt.Errorf("! needs a boolean operand")
+		}
+		e := n.eval
+		return whereNode{func(h *hostParts) any { return !e(h).(bool) }, 'b'}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *whereParser) parseCompare() (whereNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return left, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "contains", "startswith", "endswith")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return right, err
+	}
+	if left.typ != right.typ {
+		return left, fmt.Errorf("%s compares values of different types", op)
+	}
+	switch op {
+	case "<", "<=", ">", ">=":
+		if left.typ != 'i' {
+			return left, fmt.Errorf("%s needs numbers", op)
+		}
+	case "contains", "startswith", "endswith":
+		if left.typ != 's' {
+			return left, fmt.Errorf("%s needs strings", op)
+		}
+	}
+	l, r := left.eval, right.eval
+	return whereNode{func(h *hostParts) any { return compareWhere(op, l(h), r(h)) }, 'b'}, nil
+}
+
+func (p *whereParser) parsePrimary() (whereNode, error) {
+	if p.pos >= len(p.toks) {
+		return whereNode{}, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.toks[p.pos]
+	p.pos++
+	switch tok.kind {
+	case 'n':
+		n, err := strconv.Atoi(tok.text)
+		if err != nil {
+			return whereNode{}, err
+		}
+		return whereNode{func(*hostParts) any { return n }, 'i'}, nil
+	case 's':
+		s := strings.ToLower(tok.text)
+		return whereNode{func(*hostParts) any { return s }, 's'}, nil
+	case 'i':
+		switch tok.text {
+		case "true", "false":
+			b := tok.text == "true"
+			return whereNode{func(*hostParts) any { return b }, 'b'}, nil
+		}
+		if f, ok := whereFields[tok.text]; ok {
+			return f, nil
+		}
+		return whereNode{}, fmt.Errorf("unknown field %q", tok.text)
+	}
+	if tok.text == "(" {
+		n, err := p.parseOr()
+		if err != nil {
+			return n, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return n, fmt.Errorf("missing )")
+		}
+		return n, nil
+	}
+	return whereNode{}, fmt.Errorf("unexpected %q", tok.text)
+}
+
+// compareWhere applies op to operands the parser has already checked to
+// share a type that supports it.
+func compareWhere(op string, a, b any) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "contains":
+		return strings.Contains(a.(string), b.(string))
+	case "startswith":
+		return strings.HasPrefix(a.(string), b.(string))
+	case "endswith":
+		return strings.HasSuffix(a.(string), b.(string))
+	}
+	x, y := a.(int), b.(int)
+	switch op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	}
+	return x >= y
+}
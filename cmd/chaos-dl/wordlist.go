@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// labelsOnly writes, for -labels-only, the leftmost label of each match
+// once, in the order first seen, as a wordlist for further enumeration.
+// Registrable domains and wildcard lines have no such word and are
+// skipped. With -out the list goes to that file instead of w. It returns
+// the programs that had matches.
+func labelsOnly(matches <-chan Match, w io.Writer) (map[string]bool, error) {
+	var f *os.File
+	if opts.out != "" {
+		var err error
+		if f, err = os.Create(opts.out); err != nil {
+			for range matches {
+			}
+			return nil, err
+		}
+		w = f
+	}
+
+	used := make(map[string]bool)
+	seen := make(map[string]bool)
+	out, _ := newFormatter(opts.format, w)
+	for m := range matches {
+		used[m.Program] = true
+		host, _ := splitRecord(m.Subdomain)
+		host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+		if strings.HasPrefix(host, "*.") || host == registrableDomain(host) {
+			continue
+		}
+		label, _, _ := strings.Cut(host, ".")
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		out.Write(record{Text: label, Fields: []field{{"label", label}}})
+	}
+	err := out.Close()
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			fmt.Fprintf(statusOut, "[+] Wrote %s labels to %s\n", formatCount(len(seen)), opts.out)
+		}
+	}
+	return used, err
+}
@@ -1,3 +1,5 @@
 module github.com/aldenpartridge/chaos-dl
 
 go 1.25.4
+
+require golang.org/x/net v0.58.0